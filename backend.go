@@ -0,0 +1,88 @@
+// Copyright (c) 2018 Henry Slawniak <https://datacenterscumbags.com/>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-playground/log"
+)
+
+// Supported values for -backend.
+const (
+	backendAuto   = "auto"
+	backendFFMpeg = "ffmpeg"
+	backendNative = "native"
+)
+
+// frameSource probes videos and extracts frames from them.
+type frameSource interface {
+	// Name identifies the backend in logs and the info JSON.
+	Name() string
+	// Probe describes the video at path.
+	Probe(ctx context.Context, path string) (*ffprobeOutput, error)
+	// ExtractFrame writes frame of vid, -frame-width pixels wide, as a PNG
+	// at vid.framePath(frame.Index). It returns the time of the frame it
+	// wrote, which can differ from frame.Time when seeking is inexact.
+	ExtractFrame(ctx context.Context, vid *Video, frame Frame) (float64, error)
+}
+
+// source is the frameSource chosen by selectBackend.
+var source frameSource = ffmpegSource{}
+
+// selectBackend picks the frameSource named by backend. auto uses ffmpeg
+// when it can be found and the built-in decoder otherwise.
+func selectBackend(ctx context.Context, backend string) (frameSource, error) {
+	switch backend {
+	case backendFFMpeg:
+		if err := checkTools(ctx); err != nil {
+			return nil, err
+		}
+		return ffmpegSource{}, nil
+	case backendNative:
+		return &nativeSource{}, nil
+	case backendAuto:
+		if err := resolveBinaries(); err != nil {
+			log.Warnf("%s, using the built-in decoder", err)
+			return &nativeSource{ffmpegMissing: true}, nil
+		}
+		if err := checkTools(ctx); err != nil {
+			return nil, err
+		}
+		return ffmpegSource{}, nil
+	}
+	return nil, fmt.Errorf("unknown backend %q", backend)
+}
+
+// ffmpegSource uses ffprobe and ffmpeg, and handles anything they do.
+type ffmpegSource struct{}
+
+func (ffmpegSource) Name() string {
+	return backendFFMpeg
+}
+
+func (ffmpegSource) Probe(ctx context.Context, path string) (*ffprobeOutput, error) {
+	return getFFProbeMetadata(ctx, path)
+}
+
+func (ffmpegSource) ExtractFrame(ctx context.Context, vid *Video, frame Frame) (float64, error) {
+	return extractFrame(ctx, GetFFMpegBinary(), vid, frame)
+}
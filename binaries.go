@@ -21,37 +21,201 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-playground/log"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"runtime"
+	"strings"
 )
 
-// GetFFMpegBinary returns the location of the correct ffmpeg binary for the runtime OS
+// Environment variables that can point at the binaries, below the -ffmpeg and
+// -ffprobe flags in precedence.
+const (
+	FFMpegEnv  = "THUMBNAILER_FFMPEG"
+	FFProbeEnv = "THUMBNAILER_FFPROBE"
+)
+
+// The binaries found by resolveBinaries.
+var (
+	ffmpegBinary  string
+	ffprobeBinary string
+)
+
+// GetFFMpegBinary returns the location of the ffmpeg binary found at startup
 func GetFFMpegBinary() string {
-	var location string
-	if runtime.GOOS == "linux" {
-		location = "./ffmpeg"
-	} else if runtime.GOOS == "windows" {
-		location = "./ffmpeg.exe"
+	if ffmpegBinary == "" {
+		return binaryName("ffmpeg")
 	}
+	return ffmpegBinary
+}
 
-	if !FileExists(location) {
-		return "ffmpeg"
+// GetFFProbeBinary returns the location of the ffprobe binary found at startup
+func GetFFProbeBinary() string {
+	if ffprobeBinary == "" {
+		return binaryName("ffprobe")
 	}
+	return ffprobeBinary
+}
 
-	return location
+// binaryName returns the file name of the named binary on the runtime OS
+func binaryName(name string) string {
+	if runtime.GOOS == "windows" {
+		return name + ".exe"
+	}
+	return name
 }
 
-// GetFFProbeBinary returns the location of the correct ffprobe binary for the runtime OS
-func GetFFProbeBinary() string {
-	var location string
-	if runtime.GOOS == "linux" {
-		location = "./ffprobe"
-	} else if runtime.GOOS == "windows" {
-		location = "./ffprobe.exe"
+// resolveBinaries finds ffmpeg and ffprobe, see findBinary.
+func resolveBinaries() error {
+	var err error
+	ffmpegBinary, err = findBinary("ffmpeg", *ffmpegPath, FFMpegEnv)
+	if err != nil {
+		return err
 	}
+	ffprobeBinary, err = findBinary("ffprobe", *ffprobePath, FFProbeEnv)
+	return err
+}
 
-	if !FileExists(location) {
-		return "ffprobe"
+// findBinary looks for name, in order, at the path given by its flag, the
+// path in envVar, next to the thumbnailer executable and finally on PATH.
+func findBinary(name, flagValue, envVar string) (string, error) {
+	if flagValue != "" {
+		return lookBinary(flagValue)
+	}
+	if env := os.Getenv(envVar); env != "" {
+		return lookBinary(env)
 	}
 
-	return location
+	if exe, err := os.Executable(); err == nil {
+		if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+			exe = resolved
+		}
+		location := filepath.Join(filepath.Dir(exe), binaryName(name))
+		if FileExists(location) {
+			return location, nil
+		}
+	}
+
+	location, err := exec.LookPath(binaryName(name))
+	if err != nil {
+		return "", fmt.Errorf("cannot find %s: place it next to thumbnailer, on your PATH, or point -%s or %s at it", name, name, envVar)
+	}
+	return location, nil
+}
+
+func lookBinary(path string) (string, error) {
+	location, err := exec.LookPath(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot use %s: %w", path, err)
+	}
+	return location, nil
+}
+
+// toolVersion returns the first line of `binary -version`.
+func toolVersion(ctx context.Context, binary string) (string, error) {
+	out, err := runCommand(ctx, binary, "-version")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0]), nil
+}
+
+// availableFilters returns the names of the filters ffmpeg was built with.
+func availableFilters(ctx context.Context, binary string) (map[string]bool, error) {
+	out, err := runCommand(ctx, binary, "-hide_banner", "-filters")
+	if err != nil {
+		return nil, err
+	}
+
+	filters := map[string]bool{}
+	for _, line := range strings.Split(string(out), "\n") {
+		// Filter lines look like " T.C scale  V->V  Scale the input video size"
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && strings.Contains(fields[2], "->") {
+			filters[fields[1]] = true
+		}
+	}
+	return filters, nil
+}
+
+// requiredFilters returns the ffmpeg filters the current options need.
+func requiredFilters() []string {
+	filters := []string{"scale"}
+	if *subtitleMode == subtitlesBurn {
+		filters = append(filters, "subtitles")
+	}
+	return filters
+}
+
+// printToolVersions writes the location and version of ffmpeg and ffprobe,
+// and which of the filters we need are available, to stdout.
+func printToolVersions(ctx context.Context) {
+	for _, tool := range []struct{ name, flag, env string }{
+		{"ffmpeg", *ffmpegPath, FFMpegEnv},
+		{"ffprobe", *ffprobePath, FFProbeEnv},
+	} {
+		binary, err := findBinary(tool.name, tool.flag, tool.env)
+		if err != nil {
+			fmt.Printf("%s: %s\n", tool.name, err)
+			continue
+		}
+		version, err := toolVersion(ctx, binary)
+		if err != nil {
+			fmt.Printf("%s: %s: %s\n", tool.name, binary, err)
+			continue
+		}
+		fmt.Printf("%s: %s (%s)\n", tool.name, binary, version)
+
+		if tool.name != "ffmpeg" {
+			continue
+		}
+		filters, err := availableFilters(ctx, binary)
+		if err != nil {
+			fmt.Printf("filters: %s\n", err)
+			continue
+		}
+		for _, f := range requiredFilters() {
+			status := "ok"
+			if !filters[f] {
+				status = "missing"
+			}
+			fmt.Printf("filter %s: %s\n", f, status)
+		}
+	}
+}
+
+// checkTools reports the versions of ffmpeg and ffprobe, and makes sure
+// ffmpeg has the filters we need, so a broken install fails once at startup
+// rather than for every file.
+func checkTools(ctx context.Context) error {
+	if err := resolveBinaries(); err != nil {
+		return err
+	}
+
+	for _, binary := range []string{ffmpegBinary, ffprobeBinary} {
+		version, err := toolVersion(ctx, binary)
+		if err != nil {
+			return fmt.Errorf("cannot run %s: %w", binary, err)
+		}
+		log.Infof("Using %s (%s)", binary, version)
+	}
+
+	filters, err := availableFilters(ctx, ffmpegBinary)
+	if err != nil {
+		return fmt.Errorf("cannot list ffmpeg filters: %w", err)
+	}
+	var missing []string
+	for _, f := range requiredFilters() {
+		if !filters[f] {
+			missing = append(missing, f)
+		}
+	}
+	if len(missing) > 0 {
+		return errors.New("ffmpeg is missing required filters: " + strings.Join(missing, ", "))
+	}
+	return nil
 }
@@ -0,0 +1,58 @@
+// Copyright (c) 2018 Henry Slawniak <https://datacenterscumbags.com/>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+)
+
+// runCommand runs binary with args, returning its stdout. Failures are
+// reported as a *CommandError carrying stderr, or the context's error if the
+// command was killed because ctx finished.
+func runCommand(ctx context.Context, binary string, args ...string) ([]byte, error) {
+	stdout, _, err := runCommandStderr(ctx, binary, args...)
+	return stdout, err
+}
+
+// runCommandStderr is runCommand for when what the command logs to stderr
+// is needed on success too.
+func runCommandStderr(ctx context.Context, binary string, args ...string) ([]byte, []byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, binary, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return stdout.Bytes(), stderr.Bytes(), &CommandError{
+			Binary: binary,
+			Args:   args,
+			Err:    err,
+			Stderr: stderr.String(),
+		}
+	}
+	return stdout.Bytes(), stderr.Bytes(), nil
+}
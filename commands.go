@@ -0,0 +1,259 @@
+// Copyright (c) 2018 Henry Slawniak <https://datacenterscumbags.com/>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/go-playground/log"
+	"io/ioutil"
+	"os"
+)
+
+// Flags shared between commands, by name. Every flag is also accepted
+// before the command name, or without one.
+var (
+	commonFlags = []string{"config", "backend", "ffmpeg", "ffprobe", "hash", "report", "progress", "progress-fd", "file-timeout", "probe-timeout"}
+	filterFlags = []string{"include", "exclude", "min-size", "max-size", "min-duration", "max-duration", "hidden", "max-depth", "follow-symlinks"}
+	inputFlags  = append([]string{"i", "walk-directories", "files-from", "manifest"}, filterFlags...)
+	outputFlags = []string{"o", "in-place"}
+	writeFlags  = []string{"sink", "s3-endpoint", "s3-region", "s3-timeout", "embed-metadata"}
+	frameFlags  = []string{"frames", "frame-time", "frame-width", "frame-timeout", "accurate", "chapters", "frames-per-chapter", "start", "end", "skip-intro", "skip-credits", "at", "at-file", "at-frames", "subtitles", "subtitle-lang", "subtitle-file"}
+	exportFlags = []string{"frame-format", "jpeg-quality", "export-width", "full-resolution"}
+	sheetFlags  = []string{"write-info", "frames-per-row", "write-attribution", "background", "text-color", "keep-frames"}
+)
+
+// command is something thumbnailer can do, run as thumbnailer NAME.
+type command struct {
+	name        string
+	args        string
+	description string
+	flags       [][]string
+	run         func(args []string) int
+}
+
+// sheetCommand is also what runs when no command is given.
+var sheetCommand = &command{
+	name:        "sheet",
+	args:        "FILE|DIR|URL...",
+	description: "Generate a contact sheet and info JSON for each video",
+	flags:       [][]string{commonFlags, inputFlags, outputFlags, writeFlags, frameFlags, sheetFlags},
+	run:         runSheet,
+}
+
+var commands = []*command{
+	sheetCommand,
+	{
+		name:        "probe",
+		args:        "FILE|DIR|URL...",
+		description: "Inventory videos as a table, CSV or JSON lines of their duration, resolution, codec, bitrate, size and hash, without extracting any frames",
+		flags:       [][]string{commonFlags, inputFlags, {"format", "sort"}},
+		run:         runProbe,
+	},
+	{
+		name:        "frames",
+		args:        "FILE|DIR|URL...",
+		description: "Export frames from each video into a NAME.frames directory, without building a contact sheet",
+		flags:       [][]string{commonFlags, inputFlags, outputFlags, writeFlags, frameFlags, exportFlags, {"write-info"}},
+		run:         runFrames,
+	},
+	{
+		name:        "sprite",
+		args:        "FILE|DIR|URL...",
+		description: "Build a sprite sheet of small thumbnails and a WebVTT track pointing into it, for seek previews in web players",
+		flags:       [][]string{commonFlags, inputFlags, outputFlags, writeFlags, {"frames", "frame-time", "frame-timeout", "start", "end", "tile-width", "columns"}},
+		run:         runSprite,
+	},
+	{
+		name:        "serve",
+		args:        "DIR",
+		description: "Serve contact sheets for the videos in a directory over HTTP, generating them when first asked for",
+		flags:       [][]string{commonFlags, filterFlags, outputFlags, frameFlags, sheetFlags, {"addr", "embed-metadata"}},
+		run:         runServe,
+	},
+	{
+		name:        "watch",
+		args:        "DIR",
+		description: "Generate contact sheets for videos as they arrive in a directory",
+		flags:       [][]string{commonFlags, filterFlags, outputFlags, writeFlags, frameFlags, sheetFlags, exportFlags, {"settle", "poll", "poll-interval"}},
+		run:         runWatchCommand,
+	},
+	{
+		name:        "verify",
+		args:        "FILE|DIR...",
+		description: "Check each video has a contact sheet and info JSON, and that it hasn't changed since they were made",
+		flags:       [][]string{commonFlags, inputFlags, outputFlags},
+		run:         runVerify,
+	},
+	{
+		name:        "inspect",
+		args:        "IMAGE...",
+		description: "Print the source, hash, duration and frame times embedded in contact sheets, sprites and exported frames",
+		flags:       [][]string{{"format"}},
+		run:         runInspect,
+	},
+	{
+		name:        "config",
+		args:        "show [PATH]",
+		description: "Print the settings that would be used for videos in PATH, and where each came from",
+		flags:       [][]string{{"config"}},
+		run:         runConfig,
+	},
+}
+
+func findCommand(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// flagSet returns the flags the command accepts. They share their values
+// with the top level flags, so are used the same way once parsed.
+func (c *command) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(c.name, flag.ExitOnError)
+	for _, group := range c.flags {
+		for _, name := range group {
+			f := flag.Lookup(name)
+			fs.Var(f.Value, f.Name, f.Usage)
+			fs.Lookup(name).DefValue = f.DefValue
+		}
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: thumbnailer %s [flags] %s\n\n%s.\n\nFlags:\n", c.name, c.args, c.description)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: thumbnailer [flags] FILE|DIR...\n       thumbnailer COMMAND [flags] ARGS...\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %-8s %s\n", c.name, c.description)
+	}
+	fmt.Fprintf(out, "\nWithout a command thumbnailer runs sheet. To process a file or directory named\nafter a command, use thumbnailer sheet NAME or ./NAME.\nUse thumbnailer COMMAND -h for the flags each command takes.\n\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+func runSheet(args []string) int {
+	ctx, summary := setup()
+	return runJobs(ctx, summary, collectJobs(ctx, args))
+}
+
+func runFrames(args []string) int {
+	ctx, summary := setup()
+	summary.process = extractFrames
+	return runJobs(ctx, summary, collectJobs(ctx, args))
+}
+
+func runWatchCommand(args []string) int {
+	if len(args) != 1 {
+		log.Warn("Please provide a single directory to watch")
+		return exitFailure
+	}
+	ctx, summary := setup()
+	if err := runWatch(ctx, args[0], summary); err != nil {
+		log.Error(err)
+		return exitFailure
+	}
+	summary.print()
+	if err := output.Close(); err != nil {
+		log.Errorf("Cannot finish writing outputs: %s", err)
+	}
+	summary.Close()
+	return exitInterrupted
+}
+
+func runConfig(args []string) int {
+	if len(args) < 1 || args[0] != "show" || len(args) > 2 {
+		log.Warn("Usage: thumbnailer config show [PATH]")
+		return exitFailure
+	}
+	path := "."
+	if len(args) == 2 {
+		path = args[1]
+	}
+	if err := showConfig(path); err != nil {
+		log.Error(err)
+		return exitFailure
+	}
+	return exitSuccess
+}
+
+// extractFrames exports the frames of the video at path, as -keep-frames
+// does, without building a contact sheet.
+func extractFrames(ctx context.Context, path string, opts fileOptions) error {
+	if err := checkInput(path, opts); err != nil {
+		return err
+	}
+	if *fileTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *fileTimeout)
+		defer cancel()
+	}
+
+	in, err := openInput(ctx, path)
+	if err != nil {
+		return &ProcessError{Path: path, Stage: stageOpen, Err: err}
+	}
+	defer in.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	hashed := in.hashAsync(ctx, in.hashAlgorithm(*hashAlgorithm))
+
+	video, err := probeVideo(ctx, in, opts)
+	if err != nil {
+		return err
+	}
+
+	video.tempDir, err = ioutil.TempDir("", "thumbnailer-")
+	if err != nil {
+		return &ProcessError{Path: path, Stage: stageExtract, Err: err}
+	}
+	defer os.RemoveAll(video.tempDir)
+
+	if err := generateThumbnails(ctx, video); err != nil {
+		return &ProcessError{Path: path, Stage: stageExtract, Err: err}
+	}
+
+	// The hash is embedded in the images, so is needed before writing them.
+	sum, err := hashed()
+	if err != nil {
+		return &ProcessError{Path: path, Stage: stageHash, Err: err}
+	}
+	video.setHash(sum)
+	if err := exportFrames(ctx, video); err != nil {
+		return &ProcessError{Path: path, Stage: stageExtract, Err: err}
+	}
+	log.Infof("Wrote %d frames to %s", len(video.Frames), video.framesDir())
+
+	if *writeInfo {
+		if err := writeInfoJSON(video); err != nil {
+			return &ProcessError{Path: path, Stage: stageInfo, Err: err}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+// Copyright (c) 2018 Henry Slawniak <https://datacenterscumbags.com/>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ConfigEnv overrides where the user config file is read from.
+	ConfigEnv = "THUMBNAILER_CONFIG"
+	// DirConfigFile holds settings for the videos in the directory it is
+	// in and those below it.
+	DirConfigFile = ".thumbnailer.toml"
+)
+
+// globalSettings are only read once at startup, so can't be changed by a
+// directory config.
+var globalSettings = map[string]bool{
+	"addr": true, "backend": true, "config": true, "exclude": true, "ffmpeg": true,
+	"ffprobe": true, "files-from": true, "follow-symlinks": true, "format": true, "hash": true,
+	"hidden": true, "i": true, "include": true, "manifest": true, "max-depth": true,
+	"max-size": true, "min-size": true, "o": true, "poll": true, "poll-interval": true,
+	"progress": true, "progress-fd": true, "report": true, "s3-endpoint": true,
+	"s3-region": true, "s3-timeout": true, "settle": true, "sink": true, "sort": true,
+	"version": true, "walk-directories": true,
+}
+
+// settings tracks where each flag's value came from, so directory configs
+// can be layered over the command line and user config and removed again.
+type settings struct {
+	cli    map[string]bool
+	source map[string]string
+	dirs   map[string]map[string]string
+}
+
+// config is loaded in main, before then directory configs are ignored.
+var config = &settings{}
+
+// userConfigPath returns where the user config is read from, if anywhere.
+func userConfigPath() string {
+	if *configFile != "" {
+		return *configFile
+	}
+	if p := os.Getenv(ConfigEnv); p != "" {
+		return p
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "thumbnailer", "config.toml")
+}
+
+// loadSettings applies the user config to every flag not given on the
+// command line, either before the command or in fs.
+func loadSettings(fs *flag.FlagSet) (*settings, error) {
+	s := &settings{
+		cli:    map[string]bool{},
+		source: map[string]string{},
+		dirs:   map[string]map[string]string{},
+	}
+	cli := func(f *flag.Flag) {
+		s.cli[f.Name] = true
+		s.source[f.Name] = "command line"
+	}
+	flag.Visit(cli)
+	fs.Visit(cli)
+
+	if p := userConfigPath(); p != "" {
+		values, err := readConfigFile(p)
+		if err != nil && (*configFile != "" || !os.IsNotExist(err)) {
+			return nil, err
+		}
+		for name, value := range values {
+			if s.cli[name] {
+				continue
+			}
+			if err := flag.Set(name, value); err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", p, name, err)
+			}
+			s.source[name] = p
+		}
+	}
+
+	flag.VisitAll(func(f *flag.Flag) {
+		if _, ok := s.source[f.Name]; !ok {
+			s.source[f.Name] = "default"
+		}
+	})
+	return s, nil
+}
+
+// apply sets the flags from the directory configs that apply to a video
+// in dir, the closest taking precedence. The returned function restores the
+// previous values.
+func (s *settings) apply(dir string) (func(), error) {
+	if s.dirs == nil {
+		return func() {}, nil
+	}
+
+	previous := map[string]string{}
+	restore := func() {
+		for name, value := range previous {
+			flag.Set(name, value)
+		}
+	}
+
+	configs, err := s.dirConfigs(dir)
+	if err != nil {
+		return func() {}, err
+	}
+	for i := len(configs) - 1; i >= 0; i-- {
+		for name, value := range configs[i].values {
+			if s.cli[name] {
+				continue
+			}
+			if _, ok := previous[name]; !ok {
+				previous[name] = flag.Lookup(name).Value.String()
+			}
+			if err := flag.Set(name, value); err != nil {
+				restore()
+				return func() {}, fmt.Errorf("%s: %s: %w", configs[i].path, name, err)
+			}
+		}
+	}
+	if err := validateSettings(); err != nil {
+		restore()
+		return func() {}, err
+	}
+	return restore, nil
+}
+
+// sources returns where each flag's value would come from for a video in
+// dir.
+func (s *settings) sources(dir string) (map[string]string, error) {
+	sources := map[string]string{}
+	for name, source := range s.source {
+		sources[name] = source
+	}
+	configs, err := s.dirConfigs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(configs) - 1; i >= 0; i-- {
+		for name := range configs[i].values {
+			if !s.cli[name] {
+				sources[name] = configs[i].path
+			}
+		}
+	}
+	return sources, nil
+}
+
+type dirConfig struct {
+	path   string
+	values map[string]string
+}
+
+// dirConfigs returns the directory configs in dir and its parents, closest
+// first.
+func (s *settings) dirConfigs(dir string) ([]dirConfig, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []dirConfig
+	for {
+		p := filepath.Join(dir, DirConfigFile)
+		values, ok := s.dirs[p]
+		if !ok {
+			values, err = readConfigFile(p)
+			if err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			for name := range values {
+				if globalSettings[name] {
+					return nil, fmt.Errorf("%s: %s can only be set on the command line or in the user config", p, name)
+				}
+			}
+			s.dirs[p] = values
+		}
+		if values != nil {
+			configs = append(configs, dirConfig{path: p, values: values})
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return configs, nil
+		}
+		dir = parent
+	}
+}
+
+// readConfigFile reads flag values from a TOML file. Keys are flag names and
+// may be grouped in tables, such as [layout] or [theme], which are only for
+// readability.
+func readConfigFile(path string) (map[string]string, error) {
+	var raw map[string]interface{}
+	if _, err := toml.DecodeFile(path, &raw); err != nil {
+		if os.IsNotExist(err) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("reading config %s: %w", path, err)
+	}
+
+	values := map[string]string{}
+	var flatten func(map[string]interface{}) error
+	flatten = func(m map[string]interface{}) error {
+		for key, v := range m {
+			switch v := v.(type) {
+			case map[string]interface{}:
+				if err := flatten(v); err != nil {
+					return err
+				}
+				continue
+			case []interface{}:
+				items := make([]string, len(v))
+				for i, item := range v {
+					items[i] = fmt.Sprint(item)
+				}
+				values[key] = strings.Join(items, ",")
+			default:
+				values[key] = fmt.Sprint(v)
+			}
+			if flag.Lookup(key) == nil {
+				return fmt.Errorf("reading config %s: unknown setting %q", path, key)
+			}
+		}
+		return nil
+	}
+	if err := flatten(raw); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// showConfig prints the settings that would be used for a video at path,
+// and where each came from.
+func showConfig(path string) error {
+	dir := path
+	if !IsDir(path) {
+		dir = filepath.Dir(path)
+	}
+
+	sources, err := config.sources(dir)
+	if err != nil {
+		return err
+	}
+	restore, err := config.apply(dir)
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	var names []string
+	flag.VisitAll(func(f *flag.Flag) {
+		if f.Name != "version" && f.Name != "config" {
+			names = append(names, f.Name)
+		}
+	})
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := flag.Lookup(name)
+		value := f.Value.String()
+		if _, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok {
+			if _, err := strconv.ParseFloat(value, 64); err != nil {
+				value = strconv.Quote(value)
+			}
+		}
+		fmt.Printf("%s = %s # %s\n", name, value, sources[name])
+	}
+	return nil
+}
@@ -21,19 +21,18 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
 	"github.com/go-playground/log"
 	"github.com/golang/freetype"
 	"github.com/golang/freetype/truetype"
 	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/math/fixed"
 	"image"
 	"image/color"
 	"image/draw"
-	"image/png"
-	"math"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 const (
@@ -44,12 +43,21 @@ const (
 	FontSpacing = 0.7
 	FontDPI     = 72
 	HeaderSize  = 200
+
+	ChapterCaptionSize = 40
+	ChapterFontSize    = 24
+	SeparatorSize      = 2
+
+	StreamFontSize    = 18
+	StreamLineSpacing = 6
+
+	SubtitleFontSize    = 18
+	SubtitleLineSpacing = 6
+	SubtitleLines       = 3
 )
 
 var (
-	bg      = image.NewUniform(color.RGBA{0xE0, 0xEB, 0xF5, 0xff})
-	textCol = image.Black
-	font    *truetype.Font
+	font *truetype.Font
 )
 
 func init() {
@@ -60,50 +68,46 @@ func init() {
 	}
 }
 
-func generateContactSheet(vid *Video) {
+// generateContactSheet composites the extracted frames of vid into a single
+// image written to its output directory.
+func generateContactSheet(vid *Video) error {
 	var FrameWidth int
 	var FrameHeight int
 	frames := map[int]image.Image{}
-	for i := 0; i < vid.ThumbCount; i++ {
-		frameLoc := filepath.Join(os.TempDir(), fmt.Sprintf("%s-%d.png", vid.SHA1.Hex(), i))
-		if !FileExists(frameLoc) {
-			log.Errorf("Frame missing from disk `%s`\n", frameLoc)
-			return
+	for i := range vid.Frames {
+		if vid.Frames[i].Error != "" {
+			continue
 		}
-		f, err := os.Open(frameLoc)
-		if err != nil {
-			log.Error(err)
-			return
-		}
-
-		img, _, err := image.Decode(f)
+		img, err := loadFrame(vid.framePath(i))
 		if err != nil {
-			log.Error(err)
-			return
+			log.Warnf("Leaving out frame %d of %s: %s", i, vid.Filename, err)
+			vid.Frames[i].Error = err.Error()
+			continue
 		}
-		f.Close()
-		os.Remove(f.Name())
 
-		if i == 0 {
+		if len(frames) == 0 {
 			FrameWidth = img.Bounds().Dx()
 			FrameHeight = img.Bounds().Dy()
 		}
 
 		frames[i] = img
 	}
+	if len(frames) == 0 {
+		return fmt.Errorf("no frames of %s could be loaded", vid.Filename)
+	}
 
 	log.Infof("Loaded %d frames for %s", len(frames), vid.Filename)
 
-	rowCount := int(math.Max(math.Ceil(float64(vid.ThumbCount / *framesPerRow)), 1))
+	layout := layoutSheet(vid, FrameWidth, FrameHeight)
+	log.Infof("Sheet Dimmensions: %dx%d\n", layout.width, layout.height)
 
-	sheetWidth := (*framesPerRow * FrameWidth) + ((*framesPerRow + 1) * GutterSize)
-	if sheetWidth < MinSheetWidth {
-		sheetWidth = MinSheetWidth
-	}
-	sheetHeight := (HeaderSize) + (rowCount * FrameHeight) + ((rowCount + 1) * GutterSize)
-	log.Infof("Sheet Dimmensions: %dx%d\n", sheetWidth, sheetHeight)
+	sheet := image.NewRGBA(image.Rect(0, 0, layout.width, layout.height))
 
-	sheet := image.NewRGBA(image.Rect(0, 0, sheetWidth, sheetHeight))
+	// Both validated in main, or when applying a directory config
+	bgCol, _ := parseColor(*background)
+	fgCol, _ := parseColor(*textColor)
+	bg := image.NewUniform(bgCol)
+	textCol := image.NewUniform(fgCol)
 
 	draw.Draw(sheet, sheet.Bounds(), bg, image.ZP, draw.Src)
 
@@ -116,95 +120,320 @@ func generateContactSheet(vid *Video) {
 	c.SetDst(sheet)
 	c.SetSrc(textCol)
 
-	pt := freetype.Pt(10, 10+int(c.PointToFixed(FontSize)>>6))
-	for _, s := range vid.Filename {
-		_, err := c.DrawString(string(s), pt)
-		if err != nil {
-			log.Error(err)
-			return
-		}
-		pt.X += c.PointToFixed(FontSize * FontSpacing)
+	title := vid.Filename
+	if vid.Meta.Format.Tags.Title != "" {
+		title += " - " + vid.Meta.Format.Tags.Title
+	}
+	if err := drawText(c, title, freetype.Pt(10, 10+int(c.PointToFixed(FontSize)>>6)), FontSize); err != nil {
+		return err
 	}
 
-	pt = freetype.Pt(10, 20+FontSize+int(c.PointToFixed((FontSize))>>6))
-	for _, s := range "SHA1: " + vid.SHA1.Hex() {
-		_, err := c.DrawString(string(s), pt)
-		if err != nil {
-			log.Error(err)
-			return
-		}
-		pt.X += c.PointToFixed(FontSize * FontSpacing)
+	hashLine := ""
+	if vid.HashAlgorithm != hashNone {
+		hashLine = hashLabel(vid.HashAlgorithm) + ": " + vid.Hash.Hex()
+	}
+	if err := drawText(c, hashLine, freetype.Pt(10, 20+FontSize+int(c.PointToFixed((FontSize))>>6)), FontSize); err != nil {
+		return err
 	}
 
-	pt = freetype.Pt(10, 65+FontSize+int(c.PointToFixed((FontSize))>>6))
-	for _, s := range fmt.Sprintf("Duration: %s, Dimmensions: %dx%d, Bitrate: %s kbps, Codec: %s", stampToString(vid.Duration), vid.Width, vid.Height, vid.Meta.Format.BitRate, vid.Codec) {
-		_, err := c.DrawString(string(s), pt)
-		if err != nil {
-			log.Error(err)
-			return
+	details := fmt.Sprintf("Duration: %s, Dimmensions: %dx%d, Bitrate: %d kbps, Codec: %s", stampToString(vid.Duration), vid.Width, vid.Height, vid.Meta.Format.BitRate/1000, vid.Codec)
+	if err := drawText(c, details, freetype.Pt(10, 65+FontSize+int(c.PointToFixed((FontSize))>>6)), FontSize); err != nil {
+		return err
+	}
+
+	streamY := 125 + FontSize/2
+	for _, line := range layout.streamLines {
+		streamY += StreamFontSize + StreamLineSpacing
+		if err := drawText(c, line, freetype.Pt(10, streamY), StreamFontSize); err != nil {
+			return err
 		}
-		pt.X += c.PointToFixed(FontSize * FontSpacing)
 	}
 
 	if *writeAttribution {
-		c.SetFontSize(FontSize * 0.5)
-		pt = freetype.Pt(10, 150+FontSize+int(c.PointToFixed((FontSize))>>6))
-		for _, s := range "Generated by thumbnailer.net" {
-			_, err := c.DrawString(string(s), pt)
-			if err != nil {
-				log.Error(err)
-				return
-			}
-			pt.X += c.PointToFixed((FontSize * 0.5) * FontSpacing)
+		if err := drawText(c, "Generated by thumbnailer.net", freetype.Pt(10, layout.header-HeaderSize+150+FontSize+int(c.PointToFixed((FontSize))>>6)), FontSize*0.5); err != nil {
+			return err
+		}
+	}
+
+	for _, sep := range layout.separators {
+		draw.Draw(sheet, image.Rect(GutterSize/2, sep, layout.width-GutterSize/2, sep+SeparatorSize), textCol, image.ZP, draw.Src)
+	}
+	for _, caption := range layout.captions {
+		if err := drawText(c, caption.text, freetype.Pt(caption.at.X, caption.at.Y), ChapterFontSize); err != nil {
+			return err
 		}
-		c.SetFontSize(FontSize)
 	}
 
-	for i := 0; i < len(frames); i++ {
-		frame := frames[i]
-		row := i / *framesPerRow
-		yOff := (row * FrameHeight) + GutterSize + HeaderSize + (GutterSize * row)
-		col := i % *framesPerRow
-		xOff := col*FrameWidth + GutterSize + (GutterSize * col)
+	for i := range vid.Frames {
+		xOff, yOff := layout.frames[i].X, layout.frames[i].Y
 		rect := image.Rect(xOff, yOff, xOff+FrameWidth, yOff+FrameHeight)
-		draw.Draw(sheet, rect, frame, frame.Bounds().Min, draw.Src)
+		if frame, ok := frames[i]; ok {
+			draw.Draw(sheet, rect, frame, frame.Bounds().Min, draw.Src)
+		} else if err := drawPlaceholder(c, sheet, rect, textCol); err != nil {
+			return err
+		}
 
-		frameTime := stampToString(vid.Step * float64(i))
+		frameTime := stampToString(vid.Frames[i].Time)
+		switch {
+		case vid.Selection == selectionFrames:
+			frameTime = fmt.Sprintf("%s #%d", preciseStamp(vid.Frames[i].Time), vid.Frames[i].Number)
+		case vid.Selection == selectionTimestamps || vid.AccurateSeek:
+			frameTime = preciseStamp(vid.Frames[i].Time)
+		}
 		stampSize := FontSize * 0.7
 		c.SetFontSize(stampSize)
-		c.SetSrc(textCol)
 		pt := freetype.Pt(xOff, yOff+FrameHeight+int(c.PointToFixed(stampSize)>>6))
-		for _, s := range frameTime {
-			_, err := c.DrawString(string(s), pt)
-			if err != nil {
-				log.Error(err)
-				return
+		if err := drawText(c, frameTime, pt, stampSize); err != nil {
+			return err
+		}
+
+		if vid.Frames[i].Subtitle != "" {
+			lines := wrapText(vid.Frames[i].Subtitle, int(float64(FrameWidth)/(SubtitleFontSize*FontSpacing)))
+			if len(lines) > SubtitleLines {
+				lines = lines[:SubtitleLines]
+			}
+			y := yOff + FrameHeight + int(stampSize) + SubtitleLineSpacing
+			for _, line := range lines {
+				y += SubtitleFontSize + SubtitleLineSpacing
+				if err := drawText(c, line, freetype.Pt(xOff, y), SubtitleFontSize); err != nil {
+					return err
+				}
 			}
-			pt.X += c.PointToFixed(stampSize * FontSpacing)
 		}
+	}
 
+	outPath := filepath.Join(vid.GetOutputDir(), vid.Filename+".png")
+	if err := writeImage(outPath, sheet, newImageMeta(vid, vid.Frames)); err != nil {
+		return err
 	}
 
-	outFile, err := os.Create(filepath.Join(vid.GetOutputDir(), vid.Filename+".png"))
+	progress.emit(progressEvent{Event: eventSheet, Output: outPath})
+	return nil
+}
+
+// loadFrame decodes the extracted frame at path, removing it from disk.
+func loadFrame(path string) (image.Image, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		log.Error(err)
-		return
+		return nil, fmt.Errorf("frame missing from disk `%s`", path)
 	}
-	defer outFile.Close()
-
-	b := bufio.NewWriter(outFile)
-	err = png.Encode(b, sheet)
+	img, _, err := image.Decode(f)
+	f.Close()
 	if err != nil {
-		log.Error(err)
-		return
+		return nil, err
 	}
+	os.Remove(path)
+	return img, nil
+}
 
-	err = b.Flush()
-	if err != nil {
-		log.Error(err)
-		return
+// drawPlaceholder outlines rect on sheet, with a note that the frame that
+// belongs there is missing.
+func drawPlaceholder(c *freetype.Context, sheet draw.Image, rect image.Rectangle, col image.Image) error {
+	const border = 2
+	inner := rect.Inset(border)
+	for _, edge := range []image.Rectangle{
+		image.Rect(rect.Min.X, rect.Min.Y, rect.Max.X, inner.Min.Y),
+		image.Rect(rect.Min.X, inner.Max.Y, rect.Max.X, rect.Max.Y),
+		image.Rect(rect.Min.X, inner.Min.Y, inner.Min.X, inner.Max.Y),
+		image.Rect(inner.Max.X, inner.Min.Y, rect.Max.X, inner.Max.Y),
+	} {
+		draw.Draw(sheet, edge, col, image.ZP, draw.Src)
+	}
+	const note = "Frame unavailable"
+	size := FontSize * 0.7
+	width := len(note) * int(c.PointToFixed(size*FontSpacing)>>6)
+	pt := freetype.Pt(rect.Min.X+(rect.Dx()-width)/2, rect.Min.Y+rect.Dy()/2+int(c.PointToFixed(size)>>6)/2)
+	return drawText(c, note, pt, size)
+}
+
+// sheetLayout is where everything below the header goes on a contact sheet.
+type sheetLayout struct {
+	width, height int
+	// header is the height of the header, which grows with the number of
+	// streamLines.
+	header      int
+	streamLines []string
+	// frames holds the top left corner of each frame.
+	frames []image.Point
+	// separators holds the top of each line dividing chapters.
+	separators []int
+	captions   []sheetCaption
+}
+
+type sheetCaption struct {
+	text string
+	// at is the baseline the caption starts on.
+	at image.Point
+}
+
+// layoutSheet lays frames out in rows of -frames-per-row. When frames were
+// chosen per chapter every chapter starts a new row, beneath a separator and
+// its title.
+func layoutSheet(vid *Video, frameWidth, frameHeight int) sheetLayout {
+	l := sheetLayout{
+		width:  (*framesPerRow * frameWidth) + ((*framesPerRow + 1) * GutterSize),
+		frames: make([]image.Point, len(vid.Frames)),
+	}
+	if l.width < MinSheetWidth {
+		l.width = MinSheetWidth
 	}
 
+	l.streamLines = streamSummary(vid, int(float64(l.width-20)/(StreamFontSize*FontSpacing)))
+	l.header = HeaderSize + len(l.streamLines)*(StreamFontSize+StreamLineSpacing)
+
+	rowHeight := frameHeight + GutterSize
+	if *subtitleMode == subtitlesCaption && vid.Subtitles != nil {
+		rowHeight += SubtitleLines * (SubtitleFontSize + SubtitleLineSpacing)
+	}
+
+	y := l.header + GutterSize
+	col := 0
+	chapter := -1
+	for i, frame := range vid.Frames {
+		if frame.Chapter != chapter && frame.Chapter >= 0 {
+			if col > 0 {
+				y += rowHeight
+				col = 0
+			}
+			chapter = frame.Chapter
+			l.separators = append(l.separators, y)
+			l.captions = append(l.captions, sheetCaption{
+				text: vid.Chapters[chapter].Title,
+				at:   image.Pt(GutterSize, y+ChapterCaptionSize-(ChapterCaptionSize-ChapterFontSize)/2),
+			})
+			y += ChapterCaptionSize
+		} else if col == *framesPerRow {
+			y += rowHeight
+			col = 0
+		}
+
+		l.frames[i] = image.Pt(col*frameWidth+GutterSize+(GutterSize*col), y)
+		col++
+	}
+	l.height = y + rowHeight
+	if len(vid.Frames) == 0 {
+		l.height = y
+	}
+	return l
+}
+
+// streamSummary describes the audio and subtitle streams of vid in lines of
+// at most width runes.
+func streamSummary(vid *Video, width int) []string {
+	var audio []string
+	for i, stream := range vid.Meta.StreamsOfType("audio") {
+		desc := fmt.Sprintf("#%d %s", i+1, stream.CodecName)
+		if stream.ChannelLayout != "" {
+			desc += " " + stream.ChannelLayout
+		} else if stream.Channels > 0 {
+			desc += fmt.Sprintf(" %dch", stream.Channels)
+		}
+		if stream.SampleRate > 0 {
+			desc += fmt.Sprintf(" %d Hz", stream.SampleRate)
+		}
+		audio = append(audio, desc+streamLabels(stream))
+	}
+
+	var subs []string
+	for i, stream := range vid.Meta.StreamsOfType("subtitle") {
+		desc := fmt.Sprintf("#%d %s", i+1, stream.CodecName)
+		subs = append(subs, desc+streamLabels(stream))
+	}
+
+	var lines []string
+	if len(audio) > 0 {
+		lines = append(lines, wrapText("Audio: "+strings.Join(audio, ", "), width)...)
+	}
+	if len(subs) > 0 {
+		lines = append(lines, wrapText("Subtitles: "+strings.Join(subs, ", "), width)...)
+	}
+	return lines
+}
+
+// streamLabels describes the language and flags of stream.
+func streamLabels(stream ffprobeStreamInfo) string {
+	var labels string
+	if stream.Tags.Language != "" && stream.Tags.Language != "und" {
+		labels += " " + stream.Tags.Language
+	}
+	var flags []string
+	if stream.Disposition.Default != 0 {
+		flags = append(flags, "default")
+	}
+	if stream.Disposition.Forced != 0 {
+		flags = append(flags, "forced")
+	}
+	if len(flags) > 0 {
+		labels += " (" + strings.Join(flags, ", ") + ")"
+	}
+	return labels
+}
+
+// wrapText splits text into lines of at most width runes, breaking on spaces
+// where possible and keeping existing line breaks.
+func wrapText(text string, width int) []string {
+	if width < 1 {
+		width = 1
+	}
+	var lines []string
+	for _, para := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(para) {
+			for len([]rune(word)) > width {
+				if line != "" {
+					lines = append(lines, line)
+					line = ""
+				}
+				r := []rune(word)
+				lines = append(lines, string(r[:width]))
+				word = string(r[width:])
+			}
+			switch {
+			case line == "":
+				line = word
+			case len([]rune(line))+1+len([]rune(word)) <= width:
+				line += " " + word
+			default:
+				lines = append(lines, line)
+				line = word
+			}
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// drawText draws text one rune at a time with a fixed advance, starting on
+// the baseline at pt.
+func drawText(c *freetype.Context, text string, pt fixed.Point26_6, size float64) error {
+	c.SetFontSize(size)
+	for _, s := range text {
+		_, err := c.DrawString(string(s), pt)
+		if err != nil {
+			return err
+		}
+		pt.X += c.PointToFixed(size * FontSpacing)
+	}
+	return nil
+}
+
+// parseColor parses a colour written as #RGB or #RRGGBB, with or without the
+// hash.
+func parseColor(s string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	var r, g, b uint8
+	if len(hex) != 6 {
+		return color.RGBA{}, fmt.Errorf("%q is not a colour like #E0EBF5", s)
+	}
+	if _, err := fmt.Sscanf(hex, "%02x%02x%02x", &r, &g, &b); err != nil {
+		return color.RGBA{}, fmt.Errorf("%q is not a colour like #E0EBF5", s)
+	}
+	return color.RGBA{r, g, b, 0xff}, nil
 }
 
 func stampToString(stamp float64) string {
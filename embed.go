@@ -0,0 +1,282 @@
+// Copyright (c) 2018 Henry Slawniak <https://datacenterscumbags.com/>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// metaKeyword is the PNG iTXt keyword the metadata is stored under.
+const metaKeyword = "thumbnailer"
+
+// xmpNamespace identifies an XMP packet in a JPEG APP1 segment.
+const xmpNamespace = "http://ns.adobe.com/xap/1.0/\x00"
+
+// imageMeta is embedded in the images we write, so that a contact sheet
+// still says what it was made from once it's parted from its info JSON.
+type imageMeta struct {
+	Generator     string  `json:"generator"`
+	Revision      string  `json:"revision,omitempty"`
+	Built         string  `json:"built,omitempty"`
+	Source        string  `json:"source"`
+	HashAlgorithm string  `json:"hash_algorithm,omitempty"`
+	Hash          string  `json:"hash,omitempty"`
+	Duration      float64 `json:"duration"`
+	// Frames holds the time of each frame in the image, in seconds.
+	Frames []float64 `json:"frames"`
+}
+
+// newImageMeta describes an image of frames of vid.
+func newImageMeta(vid *Video, frames []Frame) *imageMeta {
+	m := &imageMeta{
+		Generator: "thumbnailer",
+		Revision:  commit,
+		Built:     buildTime,
+		Source:    vid.Filename,
+		Duration:  vid.Duration,
+		Frames:    make([]float64, len(frames)),
+	}
+	if vid.Hash != nil {
+		m.HashAlgorithm, m.Hash = vid.HashAlgorithm, vid.Hash.Hex()
+	}
+	for i, f := range frames {
+		m.Frames[i] = f.Time
+	}
+	return m
+}
+
+// software names the generator as in a PNG Software or XMP CreatorTool
+// field.
+func (m *imageMeta) software() string {
+	if m.Revision != "" {
+		return m.Generator + " " + m.Revision
+	}
+	return m.Generator
+}
+
+// embedMeta adds m to data, an image encoded in the format given by ext.
+func embedMeta(data []byte, ext string, m *imageMeta) ([]byte, error) {
+	j, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(ext) {
+	case ".png":
+		return embedPNGMeta(data, m, j)
+	case ".jpg", ".jpeg":
+		return embedJPEGMeta(data, m, j)
+	}
+	return data, nil
+}
+
+// embedPNGMeta inserts text chunks after the IHDR chunk: the Software as
+// tEXt, which is Latin-1, and the Title and metadata as iTXt, which is
+// UTF-8.
+func embedPNGMeta(data []byte, m *imageMeta, j []byte) ([]byte, error) {
+	// The signature, followed by IHDR with its 13 bytes of data.
+	const headerEnd = 8 + 12 + 13
+	if !bytes.HasPrefix(data, pngSignature) || len(data) < headerEnd || string(data[12:16]) != "IHDR" {
+		return nil, errors.New("not a PNG")
+	}
+
+	var b bytes.Buffer
+	b.Write(data[:headerEnd])
+	writePNGChunk(&b, "tEXt", []byte("Software\x00"+m.software()))
+	writePNGChunk(&b, "iTXt", iTXt("Title", m.Source))
+	writePNGChunk(&b, "iTXt", iTXt(metaKeyword, string(j)))
+	b.Write(data[headerEnd:])
+	return b.Bytes(), nil
+}
+
+// iTXt returns the data of an uncompressed iTXt chunk with no language.
+func iTXt(keyword, text string) []byte {
+	return []byte(keyword + "\x00\x00\x00\x00\x00" + text)
+}
+
+// xmpPacket is the XMP written into JPEGs, for tools that don't know about
+// our comment.
+type xmpPacket struct {
+	XMLName     xml.Name `xml:"x:xmpmeta"`
+	X           string   `xml:"xmlns:x,attr"`
+	RDF         string   `xml:"xmlns:rdf,attr"`
+	DC          string   `xml:"xmlns:dc,attr"`
+	XMP         string   `xml:"xmlns:xmp,attr"`
+	Description struct {
+		About  string `xml:"rdf:about,attr"`
+		Tool   string `xml:"xmp:CreatorTool,attr"`
+		Source string `xml:"dc:source,attr"`
+	} `xml:"rdf:RDF>rdf:Description"`
+}
+
+// embedJPEGMeta inserts an XMP APP1 segment, and a comment holding the
+// metadata, after the start of image marker.
+func embedJPEGMeta(data []byte, m *imageMeta, j []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, errors.New("not a JPEG")
+	}
+
+	p := xmpPacket{
+		X:   "adobe:ns:meta/",
+		RDF: "http://www.w3.org/1999/02/22-rdf-syntax-ns#",
+		DC:  "http://purl.org/dc/elements/1.1/",
+		XMP: "http://ns.adobe.com/xap/1.0/",
+	}
+	p.Description.Tool = m.software()
+	p.Description.Source = m.Source
+	x, err := xml.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	b.Write(data[:2])
+	if err := writeJPEGSegment(&b, 0xe1, append([]byte(xmpNamespace), x...)); err != nil {
+		return nil, err
+	}
+	if err := writeJPEGSegment(&b, 0xfe, j); err != nil {
+		return nil, err
+	}
+	b.Write(data[2:])
+	return b.Bytes(), nil
+}
+
+func writeJPEGSegment(b *bytes.Buffer, marker byte, data []byte) error {
+	// The length includes itself.
+	if len(data)+2 > 0xffff {
+		return fmt.Errorf("%d bytes is too much to fit in a JPEG segment", len(data))
+	}
+	b.Write([]byte{0xff, marker})
+	binary.Write(b, binary.BigEndian, uint16(len(data)+2))
+	b.Write(data)
+	return nil
+}
+
+// readImageMeta reads the metadata embedded in the PNG or JPEG at path.
+func readImageMeta(path string) (*imageMeta, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []byte
+	switch {
+	case bytes.HasPrefix(data, pngSignature):
+		found, err = pngMeta(data)
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		found, err = jpegMeta(data)
+	default:
+		return nil, fmt.Errorf("%s is not a PNG or JPEG", filepath.Base(path))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, errors.New("no thumbnailer metadata")
+	}
+
+	m := &imageMeta{}
+	if err := json.Unmarshal(found, m); err != nil {
+		return nil, fmt.Errorf("unreadable metadata: %w", err)
+	}
+	return m, nil
+}
+
+// pngMeta returns the text of our iTXt chunk, or nil if there isn't one.
+func pngMeta(data []byte) ([]byte, error) {
+	for p := data[len(pngSignature):]; len(p) >= 12; {
+		length := binary.BigEndian.Uint32(p[:4])
+		if uint64(length)+12 > uint64(len(p)) {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		kind := string(p[4:8])
+		chunk := p[8 : 8+length]
+		p = p[12+length:]
+
+		if kind == "IDAT" || kind == "IEND" {
+			break
+		}
+		if kind != "iTXt" || !bytes.HasPrefix(chunk, []byte(metaKeyword+"\x00")) {
+			continue
+		}
+
+		// Skip the keyword, compression flag and method, then the language
+		// and translated keyword, both NUL terminated.
+		rest := chunk[len(metaKeyword)+1:]
+		if len(rest) < 2 {
+			return nil, errors.New("invalid iTXt chunk")
+		}
+		compressed := rest[0] == 1
+		rest = rest[2:]
+		for i := 0; i < 2; i++ {
+			end := bytes.IndexByte(rest, 0)
+			if end < 0 {
+				return nil, errors.New("invalid iTXt chunk")
+			}
+			rest = rest[end+1:]
+		}
+		if !compressed {
+			return rest, nil
+		}
+		r, err := zlib.NewReader(bytes.NewReader(rest))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	}
+	return nil, nil
+}
+
+// jpegMeta returns the comment holding our metadata, or nil if there isn't
+// one.
+func jpegMeta(data []byte) ([]byte, error) {
+	for p := data[2:]; len(p) >= 4; {
+		if p[0] != 0xff {
+			return nil, errors.New("invalid JPEG marker")
+		}
+		marker := p[1]
+		// Image data follows the start of scan, there are no more
+		// segments to look at.
+		if marker == 0xda {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(p[2:4]))
+		if length < 2 || length+2 > len(p) {
+			return nil, errors.New("truncated JPEG segment")
+		}
+		segment := p[4 : 2+length]
+		p = p[2+length:]
+
+		if marker == 0xfe && bytes.Contains(segment, []byte(`"generator":"thumbnailer"`)) {
+			return segment, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2018 Henry Slawniak <https://datacenterscumbags.com/>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Stages a file passes through on its way to becoming a contact sheet, used
+// to report where processing failed.
+const (
+	stageOpen    = "open"
+	stageHash    = "hash"
+	stageProbe   = "probe"
+	stageInfo    = "info"
+	stageExtract = "extract"
+	stageSheet   = "sheet"
+	stageVerify  = "verify"
+)
+
+// errSkipped is wrapped by errors for files that are not failures as such,
+// they are just not something we can make a contact sheet from.
+var errSkipped = errors.New("skipped")
+
+// ProcessError describes why a single file could not be processed.
+type ProcessError struct {
+	Path  string
+	Stage string
+	Err   error
+}
+
+func (e *ProcessError) Error() string {
+	return fmt.Sprintf("%s: %s: %v", e.Path, e.Stage, e.Err)
+}
+
+func (e *ProcessError) Unwrap() error {
+	return e.Err
+}
+
+// FrameError is returned when a single frame could not be extracted.
+type FrameError struct {
+	Index int
+	Time  float64
+	Err   error
+}
+
+func (e *FrameError) Error() string {
+	return fmt.Sprintf("frame %d at %s: %v", e.Index, stampToString(e.Time), e.Err)
+}
+
+func (e *FrameError) Unwrap() error {
+	return e.Err
+}
+
+// CommandError is returned when ffmpeg or ffprobe exit unsuccessfully, and
+// carries whatever they wrote to stderr.
+type CommandError struct {
+	Binary string
+	Args   []string
+	Err    error
+	Stderr string
+}
+
+func (e *CommandError) Error() string {
+	msg := fmt.Sprintf("%s: %v", e.Binary, e.Err)
+	if line := lastLine(e.Stderr); line != "" {
+		msg += ": " + line
+	}
+	return msg
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// errorStage returns the stage err occurred in, if known.
+func errorStage(err error) string {
+	var pe *ProcessError
+	if errors.As(err, &pe) {
+		return pe.Stage
+	}
+	return ""
+}
+
+// errorStderr returns the stderr output of the command that caused err, if
+// any.
+func errorStderr(err error) string {
+	var ce *CommandError
+	if errors.As(err, &ce) {
+		return ce.Stderr
+	}
+	return ""
+}
+
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
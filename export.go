@@ -0,0 +1,136 @@
+// Copyright (c) 2018 Henry Slawniak <https://datacenterscumbags.com/>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// framesDir returns where exported frames of v are written.
+func (v *Video) framesDir() string {
+	return filepath.Join(v.GetOutputDir(), v.Filename+".frames")
+}
+
+// frameFileName names an exported frame after its index and timestamp, so
+// they sort in order.
+func frameFileName(frame Frame, suffix, ext string) string {
+	ms := int64(frame.Time*1000 + 0.5)
+	return fmt.Sprintf("%03d_%02d-%02d-%02d.%03d%s.%s", frame.Index, ms/3600000, ms/60000%60, ms/1000%60, ms%1000, suffix, ext)
+}
+
+// exportFrames copies the extracted frames of vid into its frames directory
+// in the -frame-format, scaled to -export-width, recording their names in
+// vid.Frames. With -full-resolution every frame is extracted again at the
+// size of the video and exported alongside.
+func exportFrames(ctx context.Context, vid *Video) error {
+	dir := vid.framesDir()
+	ext := strings.ToLower(*frameFormat)
+	for i, frame := range vid.Frames {
+		if frame.Error != "" {
+			continue
+		}
+		name := frameFileName(frame, "", ext)
+		if err := convertFrame(vid.framePath(i), filepath.Join(dir, name), *exportWidth, newImageMeta(vid, []Frame{frame})); err != nil {
+			return &FrameError{Index: i, Time: frame.Time, Err: err}
+		}
+		vid.Frames[i].File = name
+	}
+
+	if !*fullResolution {
+		return nil
+	}
+
+	full := *vid
+	full.tempDir = filepath.Join(vid.tempDir, "full")
+	full.extractWidth = vid.Width
+	full.Frames = append([]Frame(nil), vid.Frames...)
+	if err := os.MkdirAll(full.tempDir, 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(full.tempDir)
+	if err := generateThumbnails(ctx, &full); err != nil {
+		return err
+	}
+	for i, frame := range vid.Frames {
+		if frame.Error != "" || full.Frames[i].Error != "" {
+			continue
+		}
+		name := frameFileName(frame, ".full", ext)
+		if err := convertFrame(full.framePath(i), filepath.Join(dir, name), 0, newImageMeta(vid, []Frame{frame})); err != nil {
+			return &FrameError{Index: i, Time: frame.Time, Err: err}
+		}
+		vid.Frames[i].FullFile = name
+	}
+	return nil
+}
+
+// convertFrame writes the PNG frame at src to dst, in the format given by
+// its extension, scaled to width unless it is 0.
+func convertFrame(src, dst string, width int, meta *imageMeta) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	img, err := png.Decode(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if width > 0 && width != img.Bounds().Dx() {
+		img = scaleToWidth(img, width)
+	}
+	return writeImage(dst, img, meta)
+}
+
+// writeImage encodes img as a PNG or JPEG depending on the extension of
+// path, and stores it as the output at path with meta embedded, unless it is
+// nil or -embed-metadata is off.
+func writeImage(path string, img image.Image, meta *imageMeta) error {
+	var buf bytes.Buffer
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png":
+		if err := png.Encode(&buf, img); err != nil {
+			return err
+		}
+	case ".jpg", ".jpeg":
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: *jpegQuality}); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported image format %s", filepath.Ext(path))
+	}
+	if meta == nil || !*embedMetadata {
+		return writeOutput(path, buf.Bytes())
+	}
+	data, err := embedMeta(buf.Bytes(), filepath.Ext(path), meta)
+	if err != nil {
+		return err
+	}
+	return writeOutput(path, data)
+}
@@ -0,0 +1,298 @@
+// Copyright (c) 2018 Henry Slawniak <https://datacenterscumbags.com/>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// IgnoreFile lists patterns, one per line, for files and directories below
+// it that should not be walked. The syntax is a subset of .gitignore.
+const IgnoreFile = ".thumbnailerignore"
+
+// sidecarExtensions are files commonly found next to videos that are never
+// worth probing, skipped unless explicitly included.
+var sidecarExtensions = []string{".nfo", ".txt", ".json", ".srt", ".ass", ".ssa", ".vtt", ".idx", ".sub", ".md5", ".sfv", ".part", ".tmp"}
+
+// walkFilter decides which files found while walking directories are
+// processed.
+type walkFilter struct {
+	include  []string
+	exclude  []string
+	minSize  int64
+	maxSize  int64
+	hidden   bool
+	maxDepth int
+
+	mu      sync.Mutex
+	ignores map[string][]ignoreRule
+}
+
+// filter is built from the command line flags in main.
+var filter = &walkFilter{}
+
+func newWalkFilter() (*walkFilter, error) {
+	f := &walkFilter{
+		include:  splitList(*includeFiles),
+		exclude:  splitList(*excludeFiles),
+		hidden:   *walkHidden,
+		maxDepth: *maxDepth,
+		ignores:  map[string][]ignoreRule{},
+	}
+
+	var err error
+	if f.minSize, err = parseSize(*minSize); err != nil {
+		return nil, fmt.Errorf("invalid -min-size: %w", err)
+	}
+	if f.maxSize, err = parseSize(*maxSize); err != nil {
+		return nil, fmt.Errorf("invalid -max-size: %w", err)
+	}
+	for _, p := range append(f.include, f.exclude...) {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+	}
+	return f, nil
+}
+
+// skipDir reports whether the directory p, found while walking root, should
+// not be descended into.
+func (f *walkFilter) skipDir(root, p string) bool {
+	if p == root {
+		return false
+	}
+	if !f.hidden && isHidden(p) {
+		return true
+	}
+	if f.maxDepth > 0 && depth(root, p) >= f.maxDepth {
+		return true
+	}
+	return f.ignored(root, p, true)
+}
+
+// skipFile returns why the file p, found while walking root, should not be
+// processed, or an empty string if it should.
+func (f *walkFilter) skipFile(root, p string, stat os.FileInfo) string {
+	switch {
+	case isOutputFile(p):
+		return "thumbnailer output"
+	case filepath.Base(p) == IgnoreFile:
+		return "ignore file"
+	case !f.hidden && isHidden(p):
+		return "hidden"
+	case f.maxDepth > 0 && depth(root, p) > f.maxDepth:
+		return "deeper than -max-depth"
+	case len(f.include) > 0 && !matchesAny(f.include, p):
+		return "not included"
+	case matchesAny(f.exclude, p):
+		return "excluded"
+	case len(f.include) == 0 && matchesAny(sidecarExtensions, p):
+		return "not a video"
+	case f.minSize > 0 && stat.Size() < f.minSize:
+		return "smaller than -min-size"
+	case f.maxSize > 0 && stat.Size() > f.maxSize:
+		return "larger than -max-size"
+	case f.ignored(root, p, false):
+		return "listed in " + IgnoreFile
+	}
+	return ""
+}
+
+// skipPath is skipFile for a file that wasn't found by walking, so also
+// checks the directories between root and p.
+func (f *walkFilter) skipPath(root, p string, stat os.FileInfo) string {
+	for d := filepath.Dir(p); d != root && strings.HasPrefix(d, root); d = filepath.Dir(d) {
+		if f.skipDir(root, d) {
+			return "in skipped directory " + d
+		}
+	}
+	return f.skipFile(root, p, stat)
+}
+
+// ignored checks p against the ignore files in its parent directories up to
+// and including root.
+func (f *walkFilter) ignored(root, p string, dir bool) bool {
+	ignored := false
+	for d := filepath.Dir(p); ; d = filepath.Dir(d) {
+		rel, err := filepath.Rel(d, p)
+		if err != nil {
+			break
+		}
+		// Rules closer to the file take precedence, so the first ignore
+		// file with an opinion decides.
+		if match, ok := matchIgnore(f.ignoreRules(d), filepath.ToSlash(rel), dir); ok {
+			ignored = match
+			break
+		}
+		if d == root || d == filepath.Dir(d) || !strings.HasPrefix(d, root) {
+			break
+		}
+	}
+	return ignored
+}
+
+func (f *walkFilter) ignoreRules(dir string) []ignoreRule {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.ignores == nil {
+		f.ignores = map[string][]ignoreRule{}
+	}
+	rules, ok := f.ignores[dir]
+	if !ok {
+		rules = loadIgnoreFile(filepath.Join(dir, IgnoreFile))
+		f.ignores[dir] = rules
+	}
+	return rules
+}
+
+// ignoreRule is a single line of an ignore file.
+type ignoreRule struct {
+	pattern string
+	negate  bool
+	dirOnly bool
+	// anchored patterns contain a slash and match the path relative to the
+	// ignore file rather than just the name.
+	anchored bool
+}
+
+func loadIgnoreFile(path string) []ignoreRule {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var r ignoreRule
+		if strings.HasPrefix(line, "!") {
+			r.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			r.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		r.pattern = line
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// matchIgnore applies rules to rel, a slash separated path, returning
+// whether it is ignored and whether any rule matched. Later rules win.
+func matchIgnore(rules []ignoreRule, rel string, dir bool) (ignored, matched bool) {
+	for _, r := range rules {
+		if r.dirOnly && !dir {
+			continue
+		}
+		name := rel
+		if !r.anchored {
+			name = rel[strings.LastIndex(rel, "/")+1:]
+		}
+		if ok, _ := filepath.Match(r.pattern, name); ok {
+			ignored, matched = !r.negate, true
+		}
+	}
+	return ignored, matched
+}
+
+// matchesAny reports whether the name of p matches one of patterns, each
+// either a glob or an extension such as mkv or .mkv.
+func matchesAny(patterns []string, p string) bool {
+	name := filepath.Base(p)
+	for _, pattern := range patterns {
+		if !strings.ContainsAny(pattern, "*?[") {
+			if !strings.HasPrefix(pattern, ".") {
+				pattern = "." + pattern
+			}
+			if strings.EqualFold(filepath.Ext(name), pattern) {
+				return true
+			}
+			continue
+		}
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func isHidden(p string) bool {
+	name := filepath.Base(p)
+	return len(name) > 1 && strings.HasPrefix(name, ".") && name != ".."
+}
+
+// depth returns how many directories below root p is, 1 for its children.
+func depth(root, p string) int {
+	rel, err := filepath.Rel(root, p)
+	if err != nil {
+		return 0
+	}
+	return strings.Count(filepath.ToSlash(rel), "/") + 1
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// parseSize parses a size in bytes with an optional K, M, G or T suffix,
+// multiples of 1024.
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		return 0, nil
+	}
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+
+	multiplier := int64(1)
+	if i := strings.IndexAny(s, "KMGT"); i >= 0 && i == len(s)-1 {
+		multiplier = 1 << (10 * (strings.Index("KMGT", s[i:]) + 1))
+		s = s[:i]
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a size", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
@@ -0,0 +1,318 @@
+// Copyright (c) 2018 Henry Slawniak <https://datacenterscumbags.com/>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/go-playground/log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How the frames of a video were chosen.
+const (
+	selectionInterval   = "interval"
+	selectionChapters   = "chapters"
+	selectionTimestamps = "timestamps"
+	selectionFrames     = "frame numbers"
+)
+
+// ChapterLeadIn is how far into a chapter we look for its first frame, as
+// chapters often open on a fade from black.
+const ChapterLeadIn = 3.0
+
+// Frame is a single frame of a contact sheet.
+type Frame struct {
+	Index int
+	// Time is the position of the frame in seconds.
+	Time float64
+	// Chapter is the index of the chapter the frame belongs to in
+	// Video.Chapters, or -1.
+	Chapter int
+	// Subtitle is the subtitle text shown at Time, when captioning.
+	Subtitle string `json:",omitempty"`
+	// File is the name of the exported frame in the frames directory, and
+	// FullFile that of the one at the size of the video.
+	File     string `json:",omitempty"`
+	FullFile string `json:",omitempty"`
+	// Number is the frame number asked for with -at-frames.
+	Number int64 `json:",omitempty"`
+	// Target is the time the frame was asked for at, when that differs from
+	// the time of the frame found there.
+	Target float64 `json:",omitempty"`
+	// Error is why the frame could not be extracted, in which case the
+	// contact sheet shows a placeholder for it.
+	Error string `json:",omitempty"`
+}
+
+// Chapter is a chapter marker of a video.
+type Chapter struct {
+	Title string
+	Start float64
+	End   float64
+}
+
+// chaptersFromMeta converts the chapters reported by ffprobe, titling any
+// untitled ones by number.
+func chaptersFromMeta(meta *ffprobeOutput) []Chapter {
+	// Chapter times are on the container's clock, which need not start at
+	// 0, frame times are from the start of the video.
+	offset := float64(meta.Format.StartTime)
+	var chapters []Chapter
+	for i, c := range meta.Chapters {
+		title := c.Tags.Title
+		if title == "" {
+			title = "Chapter " + strconv.Itoa(i+1)
+		}
+		chapters = append(chapters, Chapter{
+			Title: title,
+			Start: float64(c.StartTime) - offset,
+			End:   float64(c.EndTime) - offset,
+		})
+	}
+	return chapters
+}
+
+// planFrames fills in vid.Frames with ThumbCount frames, Step seconds apart
+// from TrimStart.
+func planFrames(vid *Video) {
+	vid.Frames = nil
+	for i := 0; i < vid.ThumbCount; i++ {
+		vid.Frames = append(vid.Frames, Frame{
+			Index:   i,
+			Time:    vid.TrimStart + vid.Step*float64(i),
+			Chapter: -1,
+		})
+	}
+	vid.Selection = selectionInterval
+}
+
+// planChapterFrames fills in vid.Frames with perChapter frames spread across
+// each chapter, skipping the lead in of the chapter and any part of it
+// outside the trimmed range.
+func planChapterFrames(vid *Video, perChapter int) {
+	vid.Frames = nil
+	for ci, c := range vid.Chapters {
+		c.Start = math.Max(c.Start, vid.TrimStart)
+		c.End = math.Min(c.End, vid.TrimEnd)
+		length := c.End - c.Start
+		if length <= 0 {
+			continue
+		}
+		leadIn := math.Min(ChapterLeadIn, length/2)
+		step := (length - leadIn) / float64(perChapter)
+		for i := 0; i < perChapter; i++ {
+			vid.Frames = append(vid.Frames, Frame{
+				Index:   len(vid.Frames),
+				Time:    c.Start + leadIn + step*float64(i),
+				Chapter: ci,
+			})
+		}
+	}
+	vid.ThumbCount = len(vid.Frames)
+	vid.Selection = selectionChapters
+}
+
+// clampFrames moves any frame at or past the last frame of vid back onto
+// it. ffmpeg writes nothing when asked for a frame at the very end, which is
+// where the last frame lands when -frame-time divides the duration evenly.
+func clampFrames(vid *Video) {
+	frame := 0.1
+	if stream := vid.Meta.VideoStream(); stream != nil && stream.FrameRate() > 0 {
+		frame = 1 / stream.FrameRate()
+	}
+	last := math.Max(vid.Duration-frame, 0)
+	for i, f := range vid.Frames {
+		if f.Time > last {
+			vid.Frames[i].Target = f.Time
+			vid.Frames[i].Time = last
+		}
+	}
+}
+
+// planTimes fills in vid.Frames with a frame at each of times, in order,
+// leaving out any past the end of the video.
+func planTimes(vid *Video, times []float64) {
+	sort.Float64s(times)
+	vid.Frames = nil
+	for _, t := range times {
+		if vid.Duration > 0 && t > vid.Duration {
+			log.Warnf("%s is only %s long, leaving out the frame at %s", vid.Filename, preciseStamp(vid.Duration), preciseStamp(t))
+			continue
+		}
+		vid.Frames = append(vid.Frames, Frame{Index: len(vid.Frames), Time: t, Chapter: -1})
+	}
+	vid.ThumbCount = len(vid.Frames)
+	vid.Step = 0
+	vid.Selection = selectionTimestamps
+}
+
+// planFrameNumbers fills in vid.Frames with the numbered frames, counted
+// from 0, at the frame rate of the video.
+func planFrameNumbers(vid *Video, numbers []int64) error {
+	var rate float64
+	if stream := vid.Meta.VideoStream(); stream != nil {
+		rate = stream.FrameRate()
+	}
+	if rate <= 0 {
+		return fmt.Errorf("frame rate unknown, can't find frames by number")
+	}
+
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+	vid.Frames = nil
+	for _, n := range numbers {
+		t := float64(n) / rate
+		if vid.Duration > 0 && t > vid.Duration {
+			log.Warnf("%s has no frame %d, leaving it out", vid.Filename, n)
+			continue
+		}
+		vid.Frames = append(vid.Frames, Frame{Index: len(vid.Frames), Time: t, Chapter: -1, Number: n})
+	}
+	vid.ThumbCount = len(vid.Frames)
+	vid.Step = 0
+	vid.Selection = selectionFrames
+	return nil
+}
+
+// requestedTimes returns the times given by -at and -at-file, in seconds.
+func requestedTimes() ([]float64, error) {
+	list := splitList(*atTimes)
+	if *atFile != "" {
+		f, err := os.Open(*atFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				list = append(list, splitList(line)...)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	var times []float64
+	for _, s := range list {
+		t, err := parseTimestamp(s)
+		if err != nil {
+			return nil, err
+		}
+		times = append(times, t)
+	}
+	return times, nil
+}
+
+// requestedFrames returns the frame numbers given by -at-frames.
+func requestedFrames() ([]int64, error) {
+	var numbers []int64
+	for _, s := range splitList(*atFrames) {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid frame number %q", s)
+		}
+		numbers = append(numbers, n)
+	}
+	return numbers, nil
+}
+
+// parseTimestamp parses a time into a video as HH:MM:SS, MM:SS or seconds,
+// each with optional fractions, or as a duration such as 1h2m30s.
+func parseTimestamp(s string) (float64, error) {
+	if strings.ContainsAny(s, "hms") {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return 0, fmt.Errorf("invalid timestamp %q", s)
+		}
+		return d.Seconds(), nil
+	}
+
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+	var t float64
+	for _, p := range parts {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("invalid timestamp %q", s)
+		}
+		t = t*60 + v
+	}
+	return t, nil
+}
+
+// trimRange returns the part of a video duration seconds long that frames
+// should be taken from, according to -start, -end, -skip-intro and
+// -skip-credits.
+func trimRange(duration float64) (start, end float64, err error) {
+	if *skipIntro < 0 || *skipCredits < 0 || *skipIntro+*skipCredits >= 100 {
+		return 0, 0, fmt.Errorf("-skip-intro and -skip-credits must be positive percentages adding up to less than 100")
+	}
+	start = duration * *skipIntro / 100
+	end = duration - duration**skipCredits/100
+
+	if *trimStart != "" {
+		if start, err = trimPoint(*trimStart, duration); err != nil {
+			return 0, 0, err
+		}
+	}
+	if *trimEnd != "" {
+		if end, err = trimPoint(*trimEnd, duration); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if duration == 0 {
+		// Only checking the flags parse.
+		return 0, 0, nil
+	}
+	start = math.Max(0, math.Min(start, duration))
+	end = math.Max(0, math.Min(end, duration))
+	if end <= start {
+		return 0, 0, fmt.Errorf("nothing left of a %s video between %s and %s", preciseStamp(duration), preciseStamp(start), preciseStamp(end))
+	}
+	return start, end, nil
+}
+
+// trimPoint parses a time into a video, counting back from the end of the
+// video when it starts with -.
+func trimPoint(s string, duration float64) (float64, error) {
+	if strings.HasPrefix(s, "-") {
+		t, err := parseTimestamp(s[1:])
+		return duration - t, err
+	}
+	return parseTimestamp(s)
+}
+
+// preciseStamp formats seconds as HH:MM:SS.mmm.
+func preciseStamp(t float64) string {
+	ms := int64(t*1000 + 0.5)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
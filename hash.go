@@ -0,0 +1,137 @@
+// Copyright (c) 2018 Henry Slawniak <https://datacenterscumbags.com/>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"golang.org/x/crypto/blake2b"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// Supported values for -hash.
+const (
+	hashSHA1    = "sha1"
+	hashSHA256  = "sha256"
+	hashBlake2b = "blake2b"
+	hashPartial = "partial"
+	hashNone    = "none"
+)
+
+const (
+	// partialSamples chunks of partialChunkSize are read from evenly spaced
+	// offsets when using the partial hash.
+	partialSamples   = 16
+	partialChunkSize = 64 * 1024
+)
+
+// normalizeHashAlgorithm validates a -hash value, returning its canonical
+// name.
+func normalizeHashAlgorithm(algo string) (string, error) {
+	algo = strings.ToLower(strings.TrimSpace(algo))
+	switch algo {
+	case hashSHA1, hashSHA256, hashBlake2b, hashPartial, hashNone:
+		return algo, nil
+	case "fast", "blake2", "blake2b-256":
+		return hashBlake2b, nil
+	}
+	return "", fmt.Errorf("unknown hash algorithm %q", algo)
+}
+
+func newHash(algo string) hash.Hash {
+	switch algo {
+	case hashSHA256, hashPartial:
+		return sha256.New()
+	case hashBlake2b:
+		h, _ := blake2b.New256(nil)
+		return h
+	}
+	return sha1.New()
+}
+
+// hashLabel is how algo is named in the contact sheet header.
+func hashLabel(algo string) string {
+	if algo == hashPartial {
+		return "SHA256 (partial)"
+	}
+	return strings.ToUpper(algo)
+}
+
+// hashFile hashes the file at path with algo. The partial algorithm hashes the
+// file size followed by chunks sampled across the file, which is enough to
+// tell videos apart without reading all of them.
+func hashFile(ctx context.Context, path string, algo string) (hashsum, error) {
+	if algo == hashNone {
+		return nil, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := newHash(algo)
+	if algo != hashPartial {
+		if _, err := io.Copy(h, &contextReader{ctx: ctx, r: f}); err != nil {
+			return nil, err
+		}
+		return h.Sum(nil), nil
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := partialHash(ctx, h, f, stat.Size()); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+func partialHash(ctx context.Context, h hash.Hash, r io.ReaderAt, size int64) error {
+	binary.Write(h, binary.BigEndian, size)
+
+	if size <= partialSamples*partialChunkSize {
+		_, err := io.Copy(h, &contextReader{ctx: ctx, r: io.NewSectionReader(r, 0, size)})
+		return err
+	}
+
+	stride := (size - partialChunkSize) / (partialSamples - 1)
+	buf := make([]byte, partialChunkSize)
+	for i := int64(0); i < partialSamples; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := r.ReadAt(buf, i*stride)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		h.Write(buf[:n])
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2018 Henry Slawniak <https://datacenterscumbags.com/>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/go-playground/log"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// inspectRecord is the metadata read from the image at Path.
+type inspectRecord struct {
+	Path string `json:"path"`
+	*imageMeta
+}
+
+func runInspect(args []string) int {
+	switch *probeFormat {
+	case "table", "csv", "json":
+	default:
+		log.Errorf("Unknown format %q, use table, csv or json", *probeFormat)
+		return exitFailure
+	}
+	if len(args) < 1 {
+		log.Warn("Please provide a contact sheet, sprite or exported frame to inspect")
+		return exitFailure
+	}
+
+	var records []inspectRecord
+	for _, path := range args {
+		m, err := readImageMeta(path)
+		if err != nil {
+			log.Errorf("%s: %s", path, err)
+			continue
+		}
+		records = append(records, inspectRecord{Path: path, imageMeta: m})
+	}
+	if err := writeInspection(os.Stdout, *probeFormat, records); err != nil {
+		log.Error(err)
+		return exitFailure
+	}
+
+	switch len(records) {
+	case len(args):
+		return exitSuccess
+	case 0:
+		return exitFailure
+	}
+	return exitPartial
+}
+
+func writeInspection(w io.Writer, format string, records []inspectRecord) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		for _, r := range records {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"path", "source", "duration", "hash_algorithm", "hash", "frames", "generator", "revision", "built"})
+		for _, r := range records {
+			cw.Write([]string{
+				r.Path,
+				r.Source,
+				strconv.FormatFloat(r.Duration, 'f', -1, 64),
+				r.HashAlgorithm,
+				r.Hash,
+				frameList(r.Frames, func(t float64) string { return strconv.FormatFloat(t, 'f', -1, 64) }),
+				r.Generator,
+				r.Revision,
+				r.Built,
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, r := range records {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintf(tw, "%s\n", r.Path)
+		fmt.Fprintf(tw, "  Source:\t%s\n", r.Source)
+		fmt.Fprintf(tw, "  Duration:\t%s\n", preciseStamp(r.Duration))
+		if r.Hash != "" {
+			fmt.Fprintf(tw, "  %s:\t%s\n", hashLabel(r.HashAlgorithm), r.Hash)
+		}
+		fmt.Fprintf(tw, "  Frames:\t%s\n", frameList(r.Frames, preciseStamp))
+		generator := r.software()
+		if r.Built != "" {
+			generator += ", built " + r.Built
+		}
+		fmt.Fprintf(tw, "  Generator:\t%s\n", generator)
+	}
+	return tw.Flush()
+}
+
+// frameList formats frame times with stamp, separated by spaces.
+func frameList(times []float64, stamp func(float64) string) string {
+	parts := make([]string, len(times))
+	for i, t := range times {
+		parts[i] = stamp(t)
+	}
+	return strings.Join(parts, " ")
+}
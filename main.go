@@ -21,17 +21,20 @@
 package main
 
 import (
-	"crypto/sha1"
+	"context"
 	"encoding/json"
 	"flag"
+	"fmt"
 	"github.com/go-playground/log"
 	"github.com/go-playground/log/handlers/console"
 	"io"
 	"io/ioutil"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -46,18 +49,112 @@ var (
 	writeAttribution = flag.Bool("write-attribution", true, "Writed \"Generated by thumbnailer.net\" to contact sheet")
 	walkDirectories  = flag.Bool("walk-directories", true, "Walk directories provided as arguments")
 	frameTime        = flag.String("frame-time", "", "The amount of time between frames e.g. 10m, 5m, 30s")
+	fileTimeout      = flag.Duration("file-timeout", time.Hour, "The maximum time to spend on a single file, 0 disables the limit")
+	probeTimeout     = flag.Duration("probe-timeout", time.Minute, "The maximum time to wait for ffprobe on a single file, 0 disables the limit")
+	frameTimeout     = flag.Duration("frame-timeout", 2*time.Minute, "The maximum time to wait for ffmpeg to extract a single frame, 0 disables the limit")
+	reportFile       = flag.String("report", "", "Write a JSON line describing the outcome of every file to this path, - for stdout")
+	progressFormat   = flag.String("progress", "", "Emit machine-readable progress events, the only supported format is json")
+	progressFD       = flag.Int("progress-fd", 1, "The file descriptor progress events are written to")
+	byChapter        = flag.Bool("chapters", false, "Choose frames per chapter, starting a new row with the chapter title for each")
+	framesPerChapter = flag.Int("frames-per-chapter", 1, "The number of frames to generate for each chapter when using -chapters")
+	subtitleMode     = flag.String("subtitles", "", "Show subtitles on frames: burn renders them into the frame, caption writes their text beneath it")
+	subtitleLang     = flag.String("subtitle-lang", "", "The language of subtitles to show e.g. eng, defaults to the first found")
+	subtitleFile     = flag.String("subtitle-file", "", "A subtitle file (SRT, ASS or WebVTT) to use instead of sidecar or embedded subtitles")
+	ffmpegPath       = flag.String("ffmpeg", "", "The ffmpeg binary to use, overrides $"+FFMpegEnv)
+	ffprobePath      = flag.String("ffprobe", "", "The ffprobe binary to use, overrides $"+FFProbeEnv)
+	backend          = flag.String("backend", backendAuto, "How videos are decoded: ffmpeg, native (built-in, handles GIF, APNG and MJPEG AVI) or auto to use ffmpeg when it is available")
+	showVersion      = flag.Bool("version", false, "Print the version of thumbnailer, ffmpeg and ffprobe and exit")
+	hashAlgorithm    = flag.String("hash", hashSHA1, "The hash used to identify videos: sha1, sha256, blake2b, partial (size and sampled chunks) or none")
+	settleTime       = flag.Duration("settle", 5*time.Second, "In watch mode, how long a file must stop changing before it is processed")
+	pollInterval     = flag.Duration("poll-interval", 2*time.Second, "In watch mode, how often to scan for changes when polling")
+	watchPoll        = flag.Bool("poll", false, "In watch mode, poll for changes instead of using file system notifications e.g. for network shares")
+	includeFiles     = flag.String("include", "", "When walking directories, only process files matching these comma separated globs or extensions e.g. mkv,mp4,clip-*")
+	excludeFiles     = flag.String("exclude", "", "When walking directories, skip files matching these comma separated globs or extensions")
+	minSize          = flag.String("min-size", "", "When walking directories, skip files smaller than this e.g. 50M")
+	maxSize          = flag.String("max-size", "", "When walking directories, skip files larger than this e.g. 4G")
+	minDuration      = flag.Duration("min-duration", 0, "Skip videos shorter than this e.g. 30s")
+	maxDuration      = flag.Duration("max-duration", 0, "Skip videos longer than this e.g. 3h")
+	walkHidden       = flag.Bool("hidden", false, "Walk hidden files and directories, those starting with a dot")
+	filesFrom        = flag.String("files-from", "", "Read paths to process from this file, one per line or NUL separated, - for stdin")
+	manifest         = flag.String("manifest", "", "Read paths to process, with optional per-file frames, frame_time and output directory, from a JSON or CSV file, - for stdin")
+	configFile       = flag.String("config", "", "The config file to read, defaults to $"+ConfigEnv+" or thumbnailer/config.toml in the user config directory")
+	background       = flag.String("background", "#E0EBF5", "The background colour of the contact sheet")
+	textColor        = flag.String("text-color", "#000000", "The colour of text on the contact sheet")
+	followSymlinks   = flag.Bool("follow-symlinks", false, "Walk into symlinked directories, each directory is still only walked once")
+	serveAddr        = flag.String("addr", "localhost:8080", "The address to serve contact sheets on")
+	tileWidth        = flag.Int("tile-width", 160, "The width of each thumbnail in a sprite sheet")
+	spriteColumns    = flag.Int("columns", 10, "The number of thumbnails in each row of a sprite sheet")
+	probeFormat      = flag.String("format", "table", "How probe and inspect report: table, csv or json (one object per line)")
+	probeSort        = flag.String("sort", "", "Sort probe reports by path, duration, resolution, codec, bitrate or size, prefix with - for descending")
+	keepFrames       = flag.Bool("keep-frames", false, "Also write each frame of the contact sheet to NAME.frames in the output directory")
+	frameFormat      = flag.String("frame-format", "png", "The format of exported frames: png or jpg")
+	jpegQuality      = flag.Int("jpeg-quality", 90, "The quality of JPEG images, 1 to 100")
+	exportWidth      = flag.Int("export-width", 0, "The width of exported frames, 0 for the same as -frame-width")
+	fullResolution   = flag.Bool("full-resolution", false, "Also export each frame at the full size of the video")
+	atTimes          = flag.String("at", "", "Take frames at these comma separated times instead of evenly spaced ones e.g. 00:01:30,00:05:00.500,90,1h2m")
+	atFile           = flag.String("at-file", "", "Take frames at the times listed in this file, one or more per line")
+	atFrames         = flag.String("at-frames", "", "Take these comma separated frame numbers, counted from 0, instead of evenly spaced frames")
+	trimStart        = flag.String("start", "", "Only take frames after this time e.g. 00:01:30 or 90s")
+	trimEnd          = flag.String("end", "", "Only take frames before this time, or this long before the end when negative e.g. -2m")
+	skipIntro        = flag.Float64("skip-intro", 0, "Skip this percentage of the video at the start, unless -start is given")
+	skipCredits      = flag.Float64("skip-credits", 0, "Skip this percentage of the video at the end, unless -end is given")
+	accurateSeek     = flag.Bool("accurate", false, "Decode up to the exact time of each frame and stamp the time of the frame found, slower but frame exact")
+	maxDepth         = flag.Int("max-depth", 0, "How many directories deep to walk, 1 for only the files in the directory given, 0 for no limit")
+	sinkDest         = flag.String("sink", "", "Write outputs into a .tar, .tar.gz or .zip archive, or upload them to s3://BUCKET/PREFIX, instead of to disk")
+	s3Endpoint       = flag.String("s3-endpoint", "", "The URL of an S3 compatible service such as MinIO, instead of AWS")
+	s3Region         = flag.String("s3-region", "us-east-1", "The region of the S3 bucket")
+	s3Timeout        = flag.Duration("s3-timeout", 5*time.Minute, "The maximum time to spend uploading a single output to S3, 0 disables the limit")
+	embedMetadata    = flag.Bool("embed-metadata", true, "Embed the source, hash, duration and frame times in the images written, see inspect")
 
 	buildTime string
 	commit    string
 )
 
 func init() {
-	flag.Parse()
+	flag.Usage = usage
 	cLog := console.New(false)
 	log.AddHandler(cLog, log.AllLevels...)
 }
 
 func main() {
+	// Parsed here rather than in init so the tests can parse their own.
+	flag.Parse()
+	cmd, args := sheetCommand, flag.Args()
+	fs := flag.CommandLine
+	if c := findCommand(flag.Arg(0)); c != nil {
+		cmd = c
+		fs = cmd.flagSet()
+		// Errors are handled by the flag set, which exits
+		fs.Parse(flag.Args()[1:])
+		args = fs.Args()
+	}
+
+	var err error
+	config, err = loadSettings(fs)
+	if err != nil {
+		log.Error(err)
+		os.Exit(exitFailure)
+	}
+
+	if *showVersion {
+		fmt.Println("thumbnailer")
+		if buildTime != "" {
+			fmt.Printf("built: %s\n", buildTime)
+		}
+		if commit != "" {
+			fmt.Printf("revision: %s\n", commit)
+		}
+		fmt.Printf("go: %s\n", runtime.Version())
+		printToolVersions(context.Background())
+		os.Exit(exitSuccess)
+	}
+
+	os.Exit(cmd.run(args))
+}
+
+// setup prepares everything needed to process videos, exiting if anything
+// is misconfigured. The returned context is cancelled on SIGINT or SIGTERM.
+func setup() (context.Context, *runSummary) {
 	log.Infof("Starting Thumbnailer")
 	if buildTime != "" {
 		log.Info("Built: " + buildTime)
@@ -69,7 +166,113 @@ func main() {
 
 	createDirectories()
 
-	if *glob != "" {
+	if err := validateSettings(); err != nil {
+		log.Error(err)
+		os.Exit(exitFailure)
+	}
+
+	algo, err := normalizeHashAlgorithm(*hashAlgorithm)
+	if err != nil {
+		log.Error(err)
+		os.Exit(exitFailure)
+	}
+	*hashAlgorithm = algo
+
+	filter, err = newWalkFilter()
+	if err != nil {
+		log.Error(err)
+		os.Exit(exitFailure)
+	}
+
+	if *reportFile == "-" && *progressFormat != "" && *progressFD == 1 {
+		log.Error("-report - and -progress would both write to stdout, set -progress-fd or -report a file")
+		os.Exit(exitFailure)
+	}
+	if err := setupProgress(*progressFormat, *progressFD); err != nil {
+		log.Error(err)
+		os.Exit(exitFailure)
+	}
+
+	source, err = selectBackend(context.Background(), *backend)
+	if err != nil {
+		log.Error(err)
+		os.Exit(exitFailure)
+	}
+	if *subtitleMode == subtitlesBurn && source.Name() != backendFFMpeg {
+		log.Warn("Subtitles can only be burned in by ffmpeg, ignoring -subtitles")
+		*subtitleMode = ""
+	}
+
+	summary, err := newRunSummary(*reportFile)
+	if err != nil {
+		log.Errorf("Cannot open report: %s", err)
+		os.Exit(exitFailure)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		// Once we have been asked to stop, restore the default signal
+		// behaviour so a second Ctrl-C kills us outright.
+		<-ctx.Done()
+		stop()
+	}()
+
+	output, err = newOutputSink(ctx, *sinkDest)
+	if err != nil {
+		log.Errorf("Cannot open sink: %s", err)
+		os.Exit(exitFailure)
+	}
+
+	progress.start()
+	return ctx, summary
+}
+
+// collectJobs finds the files to process from the manifest, file list, glob
+// or args, walking directories, and exits if there are none to look for.
+func collectJobs(ctx context.Context, args []string) []fileJob {
+	var jobs []fileJob
+	// options apply to the files found by the path being collected.
+	var options fileOptions
+	collect := func(p string) {
+		jobs = append(jobs, fileJob{Path: p, fileOptions: options})
+	}
+	// A single walker is shared so a file reachable from more than one
+	// argument is only processed once.
+	walker := newDirWalker(ctx, collect)
+	add := func(p string) {
+		if FileExists(p) && IsDir(p) {
+			if *walkDirectories {
+				walker.Walk(p)
+			}
+		} else {
+			collect(p)
+		}
+	}
+
+	switch {
+	case *manifest != "":
+		entries, err := readManifest(*manifest)
+		if err != nil {
+			log.Error(err)
+			os.Exit(exitFailure)
+		}
+		log.Infof("Manifest lists %d paths", len(entries))
+		for _, e := range entries {
+			options = e.fileOptions
+			add(e.Path)
+		}
+		options = fileOptions{}
+	case *filesFrom != "":
+		list, err := readFileList(*filesFrom)
+		if err != nil {
+			log.Error(err)
+			os.Exit(exitFailure)
+		}
+		log.Infof("File list has %d paths", len(list))
+		for _, p := range list {
+			add(p)
+		}
+	case *glob != "":
 		matches, err := filepath.Glob(*glob)
 		if err != nil {
 			log.Error(err)
@@ -79,32 +282,104 @@ func main() {
 
 		for _, match := range matches {
 			if FileExists(match) {
-				if !IsDir(match) {
-					ProcessFile(match)
-				} else if *walkDirectories {
-					WalkDir(match)
-				}
+				add(match)
 			}
 		}
-	} else {
-		if len(flag.Args()) < 1 {
+	default:
+		if len(args) < 1 {
 			log.Warn("Please provide a file path to generate a contact sheet from")
 			log.Info("Use thumbnailer -h for a full list of options")
-			os.Exit(1)
-		}
-
-		for _, a := range flag.Args() {
-			if FileExists(a) {
-				if !IsDir(a) {
-					ProcessFile(a)
-				} else {
-					if *walkDirectories {
-						WalkDir(a)
-					}
-				}
-			}
+			os.Exit(exitFailure)
+		}
+
+		for _, a := range args {
+			add(a)
 		}
 	}
+	return jobs
+}
+
+// runJobs processes jobs in order until they are done or ctx is cancelled,
+// returning the exit code.
+func runJobs(ctx context.Context, summary *runSummary, jobs []fileJob) int {
+	paths := make([]string, len(jobs))
+	for i, job := range jobs {
+		paths[i] = job.Path
+	}
+	progress.discovered(paths)
+	for i, job := range jobs {
+		if ctx.Err() != nil {
+			break
+		}
+		summary.run(ctx, i, len(jobs), job)
+	}
+
+	summary.print()
+	code := summary.exitCode()
+	if ctx.Err() != nil {
+		log.Warn("Interrupted, exiting")
+		code = exitInterrupted
+	}
+	if err := output.Close(); err != nil {
+		log.Errorf("Cannot finish writing outputs: %s", err)
+		code = exitFailure
+	}
+	summary.Close()
+	return code
+}
+
+// validateSettings checks the flags that can be changed by directory
+// configs, normalising them where needed.
+func validateSettings() error {
+	if *frameTime != "" {
+		*frameTime = strings.Replace(*frameTime, " ", "", -1)
+		d, err := time.ParseDuration(*frameTime)
+		if err != nil {
+			return fmt.Errorf("invalid frame time: %w", err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("frame time must be positive")
+		}
+	}
+
+	switch *subtitleMode {
+	case "", subtitlesBurn, subtitlesCaption:
+	default:
+		return fmt.Errorf("unknown subtitle mode %q", *subtitleMode)
+	}
+
+	if _, err := requestedTimes(); err != nil {
+		return err
+	}
+	if _, err := requestedFrames(); err != nil {
+		return err
+	}
+	if _, _, err := trimRange(0); err != nil {
+		return err
+	}
+	if (*atTimes != "" || *atFile != "") && *atFrames != "" {
+		return fmt.Errorf("choose frames by time or by number, not both")
+	}
+	if *framesPerChapter < 1 {
+		return fmt.Errorf("frames per chapter must be at least 1")
+	}
+
+	switch strings.ToLower(*frameFormat) {
+	case "png", "jpg", "jpeg":
+	default:
+		return fmt.Errorf("unknown frame format %q, use png or jpg", *frameFormat)
+	}
+	if *jpegQuality < 1 || *jpegQuality > 100 {
+		return fmt.Errorf("jpeg quality must be between 1 and 100")
+	}
+
+	if _, err := parseColor(*background); err != nil {
+		return fmt.Errorf("invalid background: %w", err)
+	}
+	if _, err := parseColor(*textColor); err != nil {
+		return fmt.Errorf("invalid text colour: %w", err)
+	}
+	return nil
 }
 
 func createDirectories() {
@@ -119,105 +394,226 @@ func createDirectories() {
 	}
 }
 
-func WalkDir(path string) {
-	if !IsDir(path) {
-		return
+// ProcessFile generates the contact sheet and info JSON for a single video.
+// The work is abandoned, and any temporary frames removed, as soon as ctx is
+// cancelled or the per-file timeout elapses. Errors are returned as a
+// *ProcessError naming the stage that failed. Settings in opts take
+// precedence over the command line.
+func ProcessFile(ctx context.Context, path string, opts fileOptions) error {
+	if err := checkInput(path, opts); err != nil {
+		return err
 	}
-	log.Infof("Walking %s", path)
 
-	filepath.Walk(path, func(p string, stat os.FileInfo, err error) error {
-		if err != nil {
-			return nil
+	if *fileTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *fileTimeout)
+		defer cancel()
+	}
+
+	in, err := openInput(ctx, path)
+	if err != nil {
+		return &ProcessError{Path: path, Stage: stageOpen, Err: err}
+	}
+	defer in.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	hashed := in.hashAsync(ctx, in.hashAlgorithm(*hashAlgorithm))
+
+	video, err := probeVideo(ctx, in, opts)
+	if err != nil {
+		return err
+	}
+
+	video.tempDir, err = ioutil.TempDir("", "thumbnailer-")
+	if err != nil {
+		return &ProcessError{Path: path, Stage: stageExtract, Err: err}
+	}
+	defer os.RemoveAll(video.tempDir)
+
+	if err := generateThumbnails(ctx, video); err != nil {
+		return &ProcessError{Path: path, Stage: stageExtract, Err: err}
+	}
+
+	sum, err := hashed()
+	if err != nil {
+		return &ProcessError{Path: path, Stage: stageHash, Err: err}
+	}
+	video.setHash(sum)
+
+	if *keepFrames {
+		if err := exportFrames(ctx, video); err != nil {
+			return &ProcessError{Path: path, Stage: stageExtract, Err: err}
 		}
+	}
 
-		if path == p {
-			return nil
+	if *writeInfo {
+		if err := writeInfoJSON(video); err != nil {
+			return &ProcessError{Path: path, Stage: stageInfo, Err: err}
 		}
+	}
 
-		if IsDir(p) {
-			WalkDir(p)
-		} else {
-			ProcessFile(p)
+	if err := generateContactSheet(video); err != nil {
+		return &ProcessError{Path: path, Stage: stageSheet, Err: err}
+	}
+	return nil
+}
+
+// writeInfoJSON writes vid as NAME.json in its output directory.
+func writeInfoJSON(vid *Video) error {
+	j, err := json.MarshalIndent(vid, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeOutput(filepath.Join(vid.GetOutputDir(), vid.Filename+".json"), j)
+}
+
+// checkInput rejects paths that can't or shouldn't be processed, and creates
+// the output directory given in opts.
+func checkInput(path string, opts fileOptions) error {
+	// Streams are only opened once they are processed.
+	if !isStream(path) {
+		if isOutputFile(path) {
+			return &ProcessError{Path: path, Stage: stageOpen, Err: fmt.Errorf("%w: thumbnailer output", errSkipped)}
+		}
+		if _, err := os.Stat(path); err != nil {
+			return &ProcessError{Path: path, Stage: stageOpen, Err: err}
+		}
+	}
+	if opts.OutputDir != "" {
+		if err := os.MkdirAll(opts.OutputDir, 0755); err != nil {
+			return &ProcessError{Path: path, Stage: stageOpen, Err: err}
 		}
-		return nil
-	})
+	}
+	return nil
 }
 
-func ProcessFile(path string) {
-	if filepath.Ext(path) == ".json" {
-		return
+// probeVideo probes in and plans which frames to take from it, ready for
+// them to be extracted.
+func probeVideo(ctx context.Context, in *input, opts fileOptions) (*Video, error) {
+	video, err := probeMetadata(ctx, in, opts)
+	if err != nil {
+		return nil, err
+	}
+	path := in.path
+	count, step := *numFrames, *frameTime
+	if opts.Frames > 0 {
+		count, step = opts.Frames, ""
+	}
+	if opts.FrameTime != "" {
+		step = opts.FrameTime
 	}
 
-	f, err := os.Open(path)
+	video.TrimStart, video.TrimEnd, err = trimRange(video.Duration)
 	if err != nil {
-		log.Error(err)
-		return
+		return nil, &ProcessError{Path: path, Stage: stageProbe, Err: fmt.Errorf("%w: %v", errSkipped, err)}
 	}
+	length := time.Duration((video.TrimEnd - video.TrimStart) * float64(time.Second))
+
+	video.ThumbCount = count
+	video.Step = length.Seconds() / float64(video.ThumbCount)
 
-	h := sha1.New()
-	io.Copy(h, f)
-	sum := h.Sum(nil)
-	f.Close()
+	if step != "" {
+		// Validated in main, or when reading the manifest
+		frameDuration, _ := time.ParseDuration(step)
 
-	video := Video{
-		Filename: filepath.Base(path),
-		Location: path,
-		SHA1:     sum,
+		video.Step = frameDuration.Seconds()
+		video.ThumbCount = int(length.Seconds() / frameDuration.Seconds())
+	}
+
+	video.Chapters = chaptersFromMeta(video.Meta)
+	// Validated in main, or when applying a directory config
+	times, _ := requestedTimes()
+	numbers, _ := requestedFrames()
+	if len(times) > 0 {
+		planTimes(video, times)
+	} else if len(numbers) > 0 {
+		if err := planFrameNumbers(video, numbers); err != nil {
+			return nil, &ProcessError{Path: path, Stage: stageProbe, Err: err}
+		}
+	} else if *byChapter && len(video.Chapters) > 0 {
+		planChapterFrames(video, *framesPerChapter)
+	} else {
+		if *byChapter {
+			log.Infof("%s has no chapters, spacing frames evenly", video.Filename)
+		}
+		planFrames(video)
+	}
+	if len(video.Frames) == 0 {
+		return nil, &ProcessError{Path: path, Stage: stageProbe, Err: fmt.Errorf("%w: no frames to take", errSkipped)}
+	}
+	clampFrames(video)
+
+	if *subtitleMode != "" {
+		if err := attachSubtitles(ctx, video); err != nil {
+			log.Warnf("Not showing subtitles for %s: %s", video.Filename, err)
+		}
+	}
+	return video, nil
+}
+
+// probeMetadata probes in and applies the filters that only need its
+// metadata, without planning any frames.
+func probeMetadata(ctx context.Context, in *input, opts fileOptions) (*Video, error) {
+	path := in.path
+	video := &Video{
+		Filename:      in.name,
+		Location:      in.location,
+		HashAlgorithm: in.hashAlgorithm(*hashAlgorithm),
+		Backend:       source.Name(),
+		AccurateSeek:  *accurateSeek && source.Name() == backendFFMpeg,
+		outputDir:     opts.OutputDir,
+	}
+	if isStream(path) {
+		video.Source = path
 	}
 	log.Infof("Processing %s", video.Filename)
 
-	meta, err := getFFProbeMetadata(video.Location)
+	progress.emit(progressEvent{Event: eventProbing})
+	meta, err := source.Probe(ctx, video.Location)
 	if err != nil {
-		log.Errorf("Error getting metadata for %s", video.Filename)
-		log.Error(err)
-		return
+		return nil, &ProcessError{Path: path, Stage: stageProbe, Err: err}
 	}
 	video.Meta = meta
-	video.Duration = meta.DurationSeconds()
-	video.ThumbCount = *numFrames
-	video.Step = ((float64(video.Duration)) / float64(video.ThumbCount))
-
-	for _, stream := range meta.Streams {
-		if stream.CodecType == "video" && stream.AverageFrameRate != "0/0" {
-			video.Width = stream.Width
-			video.Height = stream.Height
-			video.Codec = stream.CodecName
-			break
-		}
+
+	if stream := meta.VideoStream(); stream != nil {
+		video.Width = stream.Width
+		video.Height = stream.Height
+		video.Codec = stream.CodecName
 	}
 
 	if video.Width < 1 || video.Height < 1 {
-		return
+		return nil, &ProcessError{Path: path, Stage: stageProbe, Err: fmt.Errorf("%w: no video stream", errSkipped)}
 	}
 
 	if strings.Contains(meta.Format.FormatName, "pipe") {
-		return
+		return nil, &ProcessError{Path: path, Stage: stageProbe, Err: fmt.Errorf("%w: unsupported format %s", errSkipped, meta.Format.FormatName)}
 	}
 
-	if *frameTime != "" {
-		*frameTime = strings.Replace(*frameTime, " ", "", -1)
-		frameDuration, err := time.ParseDuration(*frameTime)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		video.Step = frameDuration.Seconds()
-		video.ThumbCount = int(video.Duration / frameDuration.Seconds())
+	video.Duration, err = meta.DurationSeconds()
+	if err != nil && source.Name() == backendFFMpeg {
+		log.Infof("%s doesn't record its duration, scanning for it", video.Filename)
+		video.Duration, err = scanDuration(ctx, video.Location, float64(meta.Format.StartTime))
 	}
-
-	if *writeInfo {
-		j, _ := json.MarshalIndent(video, "", "  ")
-		ioutil.WriteFile(filepath.Join(video.GetOutputDir(), video.Filename+".json"), j, 0644)
+	if err != nil {
+		return nil, &ProcessError{Path: path, Stage: stageProbe, Err: err}
 	}
-
-	generateThumbnails(&video)
-	generateContactSheet(&video)
+	length := time.Duration(video.Duration * float64(time.Second))
+	if *minDuration > 0 && length < *minDuration {
+		return nil, &ProcessError{Path: path, Stage: stageProbe, Err: fmt.Errorf("%w: shorter than %s", errSkipped, *minDuration)}
+	}
+	if *maxDuration > 0 && length > *maxDuration {
+		return nil, &ProcessError{Path: path, Stage: stageProbe, Err: fmt.Errorf("%w: longer than %s", errSkipped, *maxDuration)}
+	}
+	return video, nil
 }
 
+// IsDir reports whether path is a directory, following symlinks. Paths that
+// can't be read, such as broken symlinks, are not directories.
 func IsDir(path string) bool {
 	stat, err := os.Stat(path)
 	if err != nil {
-		log.Panic(err)
+		return false
 	}
 	return stat.IsDir()
 }
@@ -228,3 +624,17 @@ func FileExists(path string) bool {
 	}
 	return false
 }
+
+// contextReader stops reading from r once ctx is done, so hashing a large
+// file does not outlive an interrupt.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,186 @@
+// Copyright (c) 2018 Henry Slawniak <https://datacenterscumbags.com/>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fileOptions override the command line settings for a single file.
+type fileOptions struct {
+	Frames    int    `json:"frames,omitempty"`
+	FrameTime string `json:"frame_time,omitempty"`
+	// OutputDir is where the contact sheet and info JSON are written.
+	OutputDir string `json:"output,omitempty"`
+}
+
+// fileJob is a path to process, along with any settings specific to it.
+type fileJob struct {
+	Path string `json:"path"`
+	fileOptions
+}
+
+// readFileList reads paths separated by newlines, or by NUL bytes as written
+// by find -print0, from path or stdin when path is -.
+func readFileList(path string) ([]string, error) {
+	data, err := readInput(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sep := "\n"
+	if bytes.IndexByte(data, 0) >= 0 {
+		sep = "\x00"
+	}
+
+	var paths []string
+	for _, p := range strings.Split(string(data), sep) {
+		p = strings.TrimSuffix(p, "\r")
+		if sep == "\n" {
+			p = strings.TrimSpace(p)
+		}
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths, nil
+}
+
+// readManifest reads jobs from a CSV file with a header row naming its
+// columns, or from JSON holding an array of jobs or one job per line.
+func readManifest(path string) ([]fileJob, error) {
+	data, err := readInput(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []fileJob
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case strings.EqualFold(filepath.Ext(path), ".csv"):
+		jobs, err = parseCSVManifest(data)
+	case bytes.HasPrefix(trimmed, []byte("[")):
+		err = json.Unmarshal(trimmed, &jobs)
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		dec := json.NewDecoder(bytes.NewReader(trimmed))
+		for {
+			var job fileJob
+			if err = dec.Decode(&job); err == io.EOF {
+				err = nil
+				break
+			} else if err != nil {
+				break
+			}
+			jobs = append(jobs, job)
+		}
+	default:
+		jobs, err = parseCSVManifest(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest %s: %w", path, err)
+	}
+
+	for i, job := range jobs {
+		if job.Path == "" {
+			return nil, fmt.Errorf("reading manifest %s: entry %d has no path", path, i+1)
+		}
+		if job.FrameTime != "" {
+			jobs[i].FrameTime = strings.Replace(job.FrameTime, " ", "", -1)
+			d, err := time.ParseDuration(jobs[i].FrameTime)
+			if err != nil {
+				return nil, fmt.Errorf("reading manifest %s: %s: invalid frame time: %w", path, job.Path, err)
+			}
+			if d <= 0 {
+				return nil, fmt.Errorf("reading manifest %s: %s: frame time must be positive", path, job.Path)
+			}
+		}
+		if job.Frames < 0 {
+			return nil, fmt.Errorf("reading manifest %s: %s: frames must be positive", path, job.Path)
+		}
+	}
+	return jobs, nil
+}
+
+// parseCSVManifest reads a CSV manifest, the header row must include path
+// and may include frames, frame_time and output.
+func parseCSVManifest(data []byte) ([]fileJob, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.TrimLeadingSpace = true
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	columns := map[string]int{}
+	for i, name := range rows[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["path"]; !ok {
+		return nil, fmt.Errorf("no path column in header")
+	}
+	field := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	var jobs []fileJob
+	for n, row := range rows[1:] {
+		job := fileJob{Path: field(row, "path")}
+		if job.Path == "" {
+			continue
+		}
+		if frames := field(row, "frames"); frames != "" {
+			if job.Frames, err = strconv.Atoi(frames); err != nil {
+				return nil, fmt.Errorf("line %d: invalid frames %q", n+2, frames)
+			}
+		}
+		job.FrameTime = field(row, "frame_time")
+		job.OutputDir = field(row, "output")
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func readInput(path string) ([]byte, error) {
+	if path == stdinPath {
+		if err := claimStdin(); err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(path)
+}
@@ -21,49 +21,227 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"os/exec"
+	"errors"
+	"fmt"
+	"math"
 	"strconv"
+	"strings"
 )
 
+// ffprobeOutput is the subset of `ffprobe -print_format json` output we use.
+// It is also written, as is, to the info JSON.
 type ffprobeOutput struct {
-	Streams []ffprobeStreamInfo
-	Format  struct {
-		Duration   string
-		FormatName string `json:"format_name"`
-		BitRate    string `json:"bit_rate"`
-		Size       string
-	}
+	Streams  []ffprobeStreamInfo
+	Chapters []ffprobeChapter
+	Format   ffprobeFormat
+}
+
+type ffprobeFormat struct {
+	Filename       string
+	NbStreams      int        `json:"nb_streams"`
+	FormatName     string     `json:"format_name"`
+	FormatLongName string     `json:"format_long_name"`
+	StartTime      probeFloat `json:"start_time"`
+	Duration       probeFloat
+	Size           probeInt
+	BitRate        probeInt `json:"bit_rate"`
+	Tags           ffprobeTags
 }
 
 type ffprobeStreamInfo struct {
-	Index            int
-	CodecType        string `json:"codec_type"`
-	CodecName        string `json:"codec_name"`
-	AverageFrameRate string `json:"avg_frame_rate"`
-	Width            int
-	Height           int
+	Index              int
+	CodecType          string `json:"codec_type"`
+	CodecName          string `json:"codec_name"`
+	CodecLongName      string `json:"codec_long_name"`
+	Profile            string
+	Level              int
+	AverageFrameRate   string `json:"avg_frame_rate"`
+	RealFrameRate      string `json:"r_frame_rate"`
+	TimeBase           string `json:"time_base"`
+	Width              int
+	Height             int
+	PixelFormat        string   `json:"pix_fmt"`
+	SampleAspectRatio  string   `json:"sample_aspect_ratio"`
+	DisplayAspectRatio string   `json:"display_aspect_ratio"`
+	ColorRange         string   `json:"color_range"`
+	ColorSpace         string   `json:"color_space"`
+	ColorTransfer      string   `json:"color_transfer"`
+	ColorPrimaries     string   `json:"color_primaries"`
+	SampleRate         probeInt `json:"sample_rate"`
+	Channels           int
+	ChannelLayout      string     `json:"channel_layout"`
+	BitRate            probeInt   `json:"bit_rate"`
+	FrameCount         probeInt   `json:"nb_frames"`
+	StartTime          probeFloat `json:"start_time"`
+	Duration           probeFloat
+	Disposition        ffprobeDisposition
+	Tags               ffprobeTags
+}
+
+type ffprobeDisposition struct {
+	Default         int
+	Forced          int
+	HearingImpaired int `json:"hearing_impaired"`
+}
+
+type ffprobeChapter struct {
+	ID        int64
+	TimeBase  string     `json:"time_base"`
+	StartTime probeFloat `json:"start_time"`
+	EndTime   probeFloat `json:"end_time"`
+	Tags      ffprobeTags
+}
+
+// ffprobeTags holds the tags we know about. Matroska files commonly use
+// upper case tag names, which encoding/json matches case-insensitively.
+type ffprobeTags struct {
+	Title        string `json:"title,omitempty"`
+	Language     string `json:"language,omitempty"`
+	CreationTime string `json:"creation_time,omitempty"`
+	Encoder      string `json:"encoder,omitempty"`
+	// Duration is set on the streams of Matroska and WebM files, which often
+	// don't record the duration of the container.
+	Duration string `json:"duration,omitempty"`
+}
+
+// probeFloat is a number ffprobe may print as a string, or as "N/A" when it
+// does not know.
+type probeFloat float64
+
+func (f *probeFloat) UnmarshalJSON(b []byte) error {
+	s, err := unquoteProbeNumber(b)
+	if err != nil || s == "" {
+		return err
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("ffprobe: invalid number %s", b)
+	}
+	*f = probeFloat(v)
+	return nil
+}
+
+// probeInt is an integer ffprobe may print as a string, or as "N/A" when it
+// does not know.
+type probeInt int64
+
+func (i *probeInt) UnmarshalJSON(b []byte) error {
+	s, err := unquoteProbeNumber(b)
+	if err != nil || s == "" {
+		return err
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("ffprobe: invalid integer %s", b)
+	}
+	*i = probeInt(v)
+	return nil
+}
+
+// unquoteProbeNumber returns the number held in b, which may or may not be
+// quoted, or an empty string if ffprobe did not know the value.
+func unquoteProbeNumber(b []byte) (string, error) {
+	s := string(b)
+	if s == "null" {
+		return "", nil
+	}
+	if strings.HasPrefix(s, `"`) {
+		if err := json.Unmarshal(b, &s); err != nil {
+			return "", err
+		}
+	}
+	if s == "N/A" || s == "" {
+		return "", nil
+	}
+	return s, nil
+}
+
+// DurationSeconds returns the duration of the container, falling back to
+// the longest duration reported for any of its streams.
+func (o ffprobeOutput) DurationSeconds() (float64, error) {
+	if o.Format.Duration > 0 {
+		return float64(o.Format.Duration), nil
+	}
+	var longest float64
+	for _, stream := range o.Streams {
+		d := float64(stream.Duration)
+		if d <= 0 && stream.Tags.Duration != "" {
+			d, _ = parseTimestamp(stream.Tags.Duration)
+		}
+		longest = math.Max(longest, d)
+	}
+	if longest <= 0 {
+		return 0, errors.New("ffprobe did not report a duration")
+	}
+	return longest, nil
+}
+
+// VideoStream returns the first video stream that is actually video, rather
+// than cover art, or nil if there isn't one.
+func (o *ffprobeOutput) VideoStream() *ffprobeStreamInfo {
+	for i, stream := range o.Streams {
+		if stream.CodecType == "video" && stream.AverageFrameRate != "0/0" {
+			return &o.Streams[i]
+		}
+	}
+	return nil
+}
+
+// StreamsOfType returns every stream of codecType e.g. audio or subtitle.
+func (o *ffprobeOutput) StreamsOfType(codecType string) []ffprobeStreamInfo {
+	var streams []ffprobeStreamInfo
+	for _, stream := range o.Streams {
+		if stream.CodecType == codecType {
+			streams = append(streams, stream)
+		}
+	}
+	return streams
+}
+
+// FrameRate returns the average frame rate of the stream, or 0 if unknown.
+func (s ffprobeStreamInfo) FrameRate() float64 {
+	return parseRational(s.AverageFrameRate)
 }
 
-func (o ffprobeOutput) DurationSeconds() float64 {
-	f, _ := strconv.ParseFloat(o.Format.Duration, 64)
-	return f
+// parseRational parses ffprobe's "num/den" notation.
+func parseRational(r string) float64 {
+	parts := strings.SplitN(r, "/", 2)
+	num, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return 0
+	}
+	if len(parts) == 1 {
+		return num
+	}
+	den, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil || den == 0 {
+		return 0
+	}
+	return num / den
 }
 
-func getFFProbeMetadata(path string) (*ffprobeOutput, error) {
+func getFFProbeMetadata(ctx context.Context, path string) (*ffprobeOutput, error) {
 	binary := GetFFProbeBinary()
 
-	cmd := exec.Command(
+	if *probeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *probeTimeout)
+		defer cancel()
+	}
+
+	out, err := runCommand(
+		ctx,
 		binary,
 		"-v", "error",
 		"-show_streams",
 		"-show_format",
+		"-show_chapters",
 		// "-show_entries", "format=width,height,duration_ts,duration,index,codec_type,codec_name,format_name,avg_frame_rate,bit_rate",
 		"-print_format", "json",
 		path,
 	)
-
-	out, err := cmd.CombinedOutput()
 	if err != nil {
 		return nil, err
 	}
@@ -73,3 +251,39 @@ func getFFProbeMetadata(path string) (*ffprobeOutput, error) {
 
 	return &dat, err
 }
+
+// scanDuration finds the duration of the video stream of path by reading the
+// timestamp of every packet, for files that don't record it anywhere such as
+// live captures. It's slow, as ffprobe reads the whole file.
+func scanDuration(ctx context.Context, path string, start float64) (float64, error) {
+	out, err := runCommand(
+		ctx,
+		GetFFProbeBinary(),
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "packet=pts_time,duration_time",
+		"-of", "csv=p=0",
+		path,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	var end float64
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(strings.TrimSpace(line), ",")
+		pts, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+		if len(fields) > 1 {
+			d, _ := strconv.ParseFloat(fields[1], 64)
+			pts += d
+		}
+		end = math.Max(end, pts)
+	}
+	if end-start <= 0 {
+		return 0, errors.New("no packets with timestamps")
+	}
+	return end - start, nil
+}
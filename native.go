@@ -0,0 +1,223 @@
+// Copyright (c) 2018 Henry Slawniak <https://datacenterscumbags.com/>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/image/draw"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"sync"
+)
+
+// nativeVideo is a video decoded without ffmpeg.
+type nativeVideo interface {
+	// Meta describes the video the way ffprobe would.
+	Meta() *ffprobeOutput
+	// FrameAt returns the frame shown t seconds into the video.
+	FrameAt(t float64) (image.Image, error)
+}
+
+// errNativeUnsupported is wrapped by errors for inputs the built-in decoder
+// can't read, though ffmpeg might.
+var errNativeUnsupported = errors.New("unsupported by the built-in decoder")
+
+// nativeSource decodes animated GIF and PNG, and MJPEG AVI, in pure Go. It
+// can also describe MP4 and QuickTime files, but not decode them.
+type nativeSource struct {
+	// ffmpegMissing is set when the built-in decoder stands in for an
+	// ffmpeg that wasn't found, rather than being asked for.
+	ffmpegMissing bool
+
+	// The most recently opened video is kept, as every frame of a file is
+	// extracted before moving on to the next.
+	mu    sync.Mutex
+	path  string
+	video nativeVideo
+}
+
+func (n *nativeSource) Name() string {
+	return backendNative
+}
+
+func (n *nativeSource) open(path string) (nativeVideo, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.path == path && n.video != nil {
+		return n.video, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return nil, errNativeUnsupported
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var video nativeVideo
+	switch {
+	case bytes.HasPrefix(header, []byte("GIF87a")), bytes.HasPrefix(header, []byte("GIF89a")):
+		video, err = openGIF(f, stat.Size())
+	case bytes.HasPrefix(header, pngSignature):
+		video, err = openAPNG(f, stat.Size())
+	case bytes.HasPrefix(header, []byte("RIFF")) && bytes.Equal(header[8:12], []byte("AVI ")):
+		video, err = openAVI(f, stat.Size())
+	case bytes.Equal(header[4:8], []byte("ftyp")), bytes.Equal(header[4:8], []byte("moov")):
+		video, err = openMP4(f, stat.Size())
+	default:
+		err = errNativeUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	n.path = path
+	n.video = video
+	return video, nil
+}
+
+// unsupported decides what becomes of an input the built-in decoder can't
+// read. It is skipped, unless ffmpeg wasn't found to read it instead, when it
+// fails so that the run does too.
+func (n *nativeSource) unsupported(err error) error {
+	if !errors.Is(err, errNativeUnsupported) {
+		return err
+	}
+	if n.ffmpegMissing {
+		return fmt.Errorf("%w, and ffmpeg wasn't found", err)
+	}
+	return fmt.Errorf("%w: %v", errSkipped, err)
+}
+
+func (n *nativeSource) Probe(ctx context.Context, path string) (*ffprobeOutput, error) {
+	video, err := n.open(path)
+	if err != nil {
+		return nil, n.unsupported(err)
+	}
+	meta := video.Meta()
+	meta.Format.Filename = path
+	return meta, nil
+}
+
+func (n *nativeSource) ExtractFrame(ctx context.Context, vid *Video, frame Frame) (float64, error) {
+	video, err := n.open(vid.Location)
+	if err != nil {
+		return 0, n.unsupported(err)
+	}
+	img, err := video.FrameAt(frame.Time)
+	if err != nil {
+		return 0, n.unsupported(err)
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	// Every frame is decoded, so the one shown at frame.Time is exact.
+	return frame.Time, writePNG(vid.framePath(frame.Index), scaleToWidth(img, vid.thumbWidth()))
+}
+
+// scaleToWidth scales img to width pixels wide, keeping its aspect ratio.
+func scaleToWidth(img image.Image, width int) image.Image {
+	b := img.Bounds()
+	if width <= 0 || width == b.Dx() {
+		return img
+	}
+	height := (b.Dy()*width + b.Dx()/2) / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	b := bufio.NewWriter(f)
+	if err := png.Encode(b, img); err != nil {
+		return err
+	}
+	if err := b.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// frameIndexAt returns the index of the frame shown at t, given when each
+// frame stops being shown.
+func frameIndexAt(ends []float64, t float64) int {
+	for i, end := range ends {
+		if t < end {
+			return i
+		}
+	}
+	return len(ends) - 1
+}
+
+// nativeMeta builds the ffprobe description of a single video stream.
+func nativeMeta(formatName, codec string, width, height, frames int, duration float64, size int64) *ffprobeOutput {
+	rate := "0/1"
+	if duration > 0 {
+		// Frames per millisecond, as ffprobe would print it.
+		rate = fmt.Sprintf("%d/%d", frames*1000, int(duration*1000+0.5))
+	}
+	meta := &ffprobeOutput{
+		Streams: []ffprobeStreamInfo{{
+			CodecType:        "video",
+			CodecName:        codec,
+			AverageFrameRate: rate,
+			RealFrameRate:    rate,
+			Width:            width,
+			Height:           height,
+			FrameCount:       probeInt(frames),
+			Duration:         probeFloat(duration),
+		}},
+	}
+	meta.Format.NbStreams = 1
+	meta.Format.FormatName = formatName
+	meta.Format.Duration = probeFloat(duration)
+	meta.Format.Size = probeInt(size)
+	if duration > 0 {
+		meta.Format.BitRate = probeInt(float64(size*8) / duration)
+	}
+	return meta
+}
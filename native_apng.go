@@ -0,0 +1,236 @@
+// Copyright (c) 2018 Henry Slawniak <https://datacenterscumbags.com/>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"io/ioutil"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// APNG frame disposal and blending operations.
+const (
+	apngDisposeNone       = 0
+	apngDisposeBackground = 1
+	apngDisposePrevious   = 2
+
+	apngBlendSource = 0
+
+	// maxAPNGSide bounds the canvas, which is allocated up front, so that a
+	// corrupt header can't ask for gigabytes.
+	maxAPNGSide = 1 << 14
+)
+
+type apngFrame struct {
+	width, height int
+	x, y          int
+	delay         float64
+	dispose       byte
+	blend         byte
+	data          []byte
+}
+
+type apngVideo struct {
+	width, height int
+	ihdr          []byte
+	// palette holds the PLTE and tRNS chunks, which every frame needs.
+	palette []byte
+	frames  []*apngFrame
+	ends    []float64
+	size    int64
+}
+
+func openAPNG(r io.Reader, size int64) (nativeVideo, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errors.New("apng: missing signature")
+	}
+
+	v := &apngVideo{size: size}
+	animated := false
+	var cur *apngFrame
+
+	for p := data[len(pngSignature):]; len(p) >= 12; {
+		length := binary.BigEndian.Uint32(p[:4])
+		if uint64(length)+12 > uint64(len(p)) {
+			return nil, errors.New("apng: truncated chunk")
+		}
+		kind := string(p[4:8])
+		chunk := p[8 : 8+length]
+		raw := p[:12+length]
+		p = p[12+length:]
+
+		switch kind {
+		case "IHDR":
+			if len(chunk) != 13 {
+				return nil, errors.New("apng: invalid IHDR")
+			}
+			v.ihdr = chunk
+			v.width = int(binary.BigEndian.Uint32(chunk[0:4]))
+			v.height = int(binary.BigEndian.Uint32(chunk[4:8]))
+		case "PLTE", "tRNS":
+			v.palette = append(v.palette, raw...)
+		case "acTL":
+			animated = true
+		case "fcTL":
+			if len(chunk) != 26 {
+				return nil, errors.New("apng: invalid fcTL")
+			}
+			num := float64(binary.BigEndian.Uint16(chunk[20:22]))
+			den := float64(binary.BigEndian.Uint16(chunk[22:24]))
+			if den == 0 {
+				den = 100
+			}
+			cur = &apngFrame{
+				width:   int(binary.BigEndian.Uint32(chunk[4:8])),
+				height:  int(binary.BigEndian.Uint32(chunk[8:12])),
+				x:       int(binary.BigEndian.Uint32(chunk[12:16])),
+				y:       int(binary.BigEndian.Uint32(chunk[16:20])),
+				delay:   num / den,
+				dispose: chunk[24],
+				blend:   chunk[25],
+			}
+			v.frames = append(v.frames, cur)
+		case "IDAT":
+			// The default image is only part of the animation when a
+			// fcTL precedes it.
+			if cur != nil {
+				cur.data = append(cur.data, chunk...)
+			}
+		case "fdAT":
+			if cur == nil || len(chunk) < 4 {
+				return nil, errors.New("apng: fdAT without fcTL")
+			}
+			cur.data = append(cur.data, chunk[4:]...)
+		case "IEND":
+			p = nil
+		}
+	}
+
+	if !animated || len(v.frames) == 0 {
+		return nil, fmt.Errorf("%w: still PNG image", errSkipped)
+	}
+	if v.ihdr == nil {
+		return nil, errors.New("apng: missing IHDR")
+	}
+	if v.width < 1 || v.height < 1 || v.width > maxAPNGSide || v.height > maxAPNGSide {
+		return nil, fmt.Errorf("apng: invalid size %dx%d", v.width, v.height)
+	}
+	for i, f := range v.frames {
+		if f.x < 0 || f.y < 0 || f.width < 1 || f.height < 1 || f.x+f.width > v.width || f.y+f.height > v.height {
+			return nil, fmt.Errorf("apng: frame %d lies outside the image", i)
+		}
+	}
+
+	var t float64
+	for _, f := range v.frames {
+		t += f.delay
+		v.ends = append(v.ends, t)
+	}
+	return v, nil
+}
+
+func (v *apngVideo) Meta() *ffprobeOutput {
+	return nativeMeta("apng", "apng", v.width, v.height, len(v.frames), v.ends[len(v.ends)-1], v.size)
+}
+
+// decodeFrame turns the image data of f into a standalone PNG and decodes it.
+func (v *apngVideo) decodeFrame(f *apngFrame) (image.Image, error) {
+	ihdr := append([]byte(nil), v.ihdr...)
+	binary.BigEndian.PutUint32(ihdr[0:4], uint32(f.width))
+	binary.BigEndian.PutUint32(ihdr[4:8], uint32(f.height))
+
+	var b bytes.Buffer
+	b.Write(pngSignature)
+	writePNGChunk(&b, "IHDR", ihdr)
+	b.Write(v.palette)
+	writePNGChunk(&b, "IDAT", f.data)
+	writePNGChunk(&b, "IEND", nil)
+	return png.Decode(&b)
+}
+
+// FrameAt composites every frame up to the one shown at t, honouring each
+// frame's blend and dispose operations.
+func (v *apngVideo) FrameAt(t float64) (image.Image, error) {
+	target := frameIndexAt(v.ends, t)
+	canvas := image.NewRGBA(image.Rect(0, 0, v.width, v.height))
+
+	for i := 0; i <= target; i++ {
+		f := v.frames[i]
+		img, err := v.decodeFrame(f)
+		if err != nil {
+			return nil, fmt.Errorf("apng: frame %d: %w", i, err)
+		}
+		region := image.Rect(f.x, f.y, f.x+f.width, f.y+f.height)
+
+		dispose := f.dispose
+		if i == 0 && dispose == apngDisposePrevious {
+			dispose = apngDisposeBackground
+		}
+		var previous *image.RGBA
+		if dispose == apngDisposePrevious {
+			previous = image.NewRGBA(region)
+			draw.Draw(previous, region, canvas, region.Min, draw.Src)
+		}
+
+		op := draw.Over
+		if f.blend == apngBlendSource {
+			op = draw.Src
+		}
+		draw.Draw(canvas, region, img, img.Bounds().Min, op)
+		if i == target {
+			break
+		}
+
+		switch dispose {
+		case apngDisposeBackground:
+			draw.Draw(canvas, region, image.Transparent, image.ZP, draw.Src)
+		case apngDisposePrevious:
+			draw.Draw(canvas, region, previous, region.Min, draw.Src)
+		}
+	}
+	return canvas, nil
+}
+
+func writePNGChunk(w io.Writer, kind string, data []byte) {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	copy(header[4:], kind)
+	w.Write(header[:])
+	w.Write(data)
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	binary.Write(w, binary.BigEndian, crc.Sum32())
+}
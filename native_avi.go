@@ -0,0 +1,269 @@
+// Copyright (c) 2018 Henry Slawniak <https://datacenterscumbags.com/>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+	"strings"
+)
+
+// mjpegFourCCs are the AVI codecs whose frames are plain JPEG images.
+var mjpegFourCCs = map[string]bool{
+	"MJPG": true,
+	"mjpg": true,
+	"AVRn": true,
+	"AVDJ": true,
+	"dmb1": true,
+	"JPEG": true,
+	"jpeg": true,
+}
+
+type aviChunk struct {
+	offset int64
+	size   int64
+}
+
+type aviVideo struct {
+	path   string
+	size   int64
+	codec  string
+	width  int
+	height int
+	scale  uint32
+	rate   uint32
+
+	// The stream number, and stream count so far, while parsing.
+	videoStream int
+	streams     int
+
+	frames []aviChunk
+}
+
+func openAVI(f *os.File, size int64) (nativeVideo, error) {
+	v := &aviVideo{path: f.Name(), size: size, videoStream: -1}
+	if err := v.parse(f, 0, size, 0); err != nil {
+		return nil, err
+	}
+	if v.videoStream < 0 || v.rate == 0 || v.scale == 0 {
+		return nil, fmt.Errorf("%w: avi without a video stream", errSkipped)
+	}
+	if !mjpegFourCCs[v.codec] {
+		return nil, fmt.Errorf("%w: AVI with %s video", errNativeUnsupported, strings.Trim(v.codec, "\x00 "))
+	}
+	return v, nil
+}
+
+// parse walks the RIFF chunks between start and end.
+func (v *aviVideo) parse(r io.ReaderAt, start, end int64, depth int) error {
+	if depth > 8 {
+		return errors.New("avi: lists nested too deeply")
+	}
+	header := make([]byte, 12)
+	for off := start; off+8 <= end; {
+		if _, err := r.ReadAt(header[:8], off); err != nil {
+			return fmt.Errorf("avi: %w", err)
+		}
+		id := string(header[:4])
+		size := int64(binary.LittleEndian.Uint32(header[4:8]))
+		body := off + 8
+		if body+size > end {
+			// Files that were still being written when cut short.
+			size = end - body
+		}
+
+		switch id {
+		case "RIFF", "LIST":
+			if _, err := r.ReadAt(header[8:12], body); err != nil {
+				return fmt.Errorf("avi: %w", err)
+			}
+			if string(header[8:12]) == "strl" {
+				v.streams++
+			}
+			if err := v.parse(r, body+4, body+size, depth+1); err != nil {
+				return err
+			}
+		case "strh", "strf":
+			data := make([]byte, size)
+			if _, err := r.ReadAt(data, body); err != nil {
+				return fmt.Errorf("avi: %w", err)
+			}
+			v.header(id, data)
+		default:
+			if v.videoStream >= 0 && len(id) == 4 && (id[2:] == "dc" || id[2:] == "db") && id[:2] == fmt.Sprintf("%02d", v.videoStream) {
+				v.frames = append(v.frames, aviChunk{offset: body, size: size})
+			}
+		}
+
+		off = body + size + size%2
+	}
+	return nil
+}
+
+// header reads the stream header and format chunks of the first video stream.
+func (v *aviVideo) header(id string, data []byte) {
+	stream := v.streams - 1
+	switch id {
+	case "strh":
+		if len(data) < 28 || string(data[:4]) != "vids" || v.videoStream >= 0 {
+			return
+		}
+		v.videoStream = stream
+		v.codec = string(data[4:8])
+		v.scale = binary.LittleEndian.Uint32(data[20:24])
+		v.rate = binary.LittleEndian.Uint32(data[24:28])
+	case "strf":
+		if stream != v.videoStream || len(data) < 20 {
+			return
+		}
+		v.width = int(int32(binary.LittleEndian.Uint32(data[4:8])))
+		v.height = int(int32(binary.LittleEndian.Uint32(data[8:12])))
+		if v.height < 0 {
+			v.height = -v.height
+		}
+		if compression := string(data[16:20]); strings.Trim(compression, "\x00") != "" {
+			v.codec = compression
+		}
+	}
+}
+
+func (v *aviVideo) frameDuration() float64 {
+	return float64(v.scale) / float64(v.rate)
+}
+
+// Meta describes the video, which openAVI has checked is MJPEG.
+func (v *aviVideo) Meta() *ffprobeOutput {
+	duration := float64(len(v.frames)) * v.frameDuration()
+	return nativeMeta("avi", "mjpeg", v.width, v.height, len(v.frames), duration, v.size)
+}
+
+func (v *aviVideo) FrameAt(t float64) (image.Image, error) {
+	if len(v.frames) == 0 {
+		return nil, errors.New("avi: no frames")
+	}
+
+	i := int(t / v.frameDuration())
+	if i >= len(v.frames) {
+		i = len(v.frames) - 1
+	}
+	// Empty chunks are dropped frames, which repeat the previous one.
+	for i > 0 && v.frames[i].size == 0 {
+		i--
+	}
+
+	f, err := os.Open(v.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data := make([]byte, v.frames[i].size)
+	if _, err := f.ReadAt(data, v.frames[i].offset); err != nil {
+		return nil, fmt.Errorf("avi: frame %d: %w", i, err)
+	}
+	return decodeMJPEG(data)
+}
+
+// decodeMJPEG decodes a single MJPEG frame. Many cameras leave out the
+// Huffman tables, relying on the standard ones, which image/jpeg requires.
+func decodeMJPEG(data []byte) (image.Image, error) {
+	if len(data) > 2 && !hasJPEGMarker(data, 0xC4) {
+		fixed := make([]byte, 0, len(data)+len(standardJPEGHuffmanTables))
+		fixed = append(fixed, data[:2]...)
+		fixed = append(fixed, standardJPEGHuffmanTables...)
+		data = append(fixed, data[2:]...)
+	}
+	return jpeg.Decode(bytes.NewReader(data))
+}
+
+// hasJPEGMarker reports whether marker appears among the segments before the
+// image data.
+func hasJPEGMarker(data []byte, marker byte) bool {
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return false
+		}
+		m := data[i+1]
+		if m == marker {
+			return true
+		}
+		if m == 0xDA {
+			return false
+		}
+		i += 2 + int(binary.BigEndian.Uint16(data[i+2:i+4]))
+	}
+	return false
+}
+
+// standardJPEGHuffmanTables is a DHT segment holding the tables from section
+// K.3 of the JPEG specification.
+var standardJPEGHuffmanTables = func() []byte {
+	tables := []struct {
+		class  byte
+		counts [16]byte
+		values []byte
+	}{
+		{0x00, [16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0}, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}},
+		{0x10, [16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 0x7d}, []byte{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12, 0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08, 0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea, 0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		}},
+		{0x01, [16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0}, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}},
+		{0x11, [16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 0x77}, []byte{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21, 0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91, 0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34, 0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		}},
+	}
+
+	var body []byte
+	for _, t := range tables {
+		body = append(body, t.class)
+		body = append(body, t.counts[:]...)
+		body = append(body, t.values...)
+	}
+	segment := []byte{0xFF, 0xC4, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(body)+2))
+	return append(segment, body...)
+}()
@@ -0,0 +1,97 @@
+// Copyright (c) 2018 Henry Slawniak <https://datacenterscumbags.com/>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"image"
+	"image/draw"
+	"image/gif"
+	"io"
+)
+
+// DefaultGIFDelay is the delay, in 100ths of a second, used for frames that
+// do not set one, matching what browsers do.
+const DefaultGIFDelay = 10
+
+type gifVideo struct {
+	g    *gif.GIF
+	ends []float64
+	size int64
+}
+
+func openGIF(r io.Reader, size int64) (nativeVideo, error) {
+	g, err := gif.DecodeAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &gifVideo{g: g, size: size}
+	var t float64
+	for _, delay := range g.Delay {
+		if delay <= 0 {
+			delay = DefaultGIFDelay
+		}
+		t += float64(delay) / 100
+		v.ends = append(v.ends, t)
+	}
+	return v, nil
+}
+
+func (v *gifVideo) Meta() *ffprobeOutput {
+	var duration float64
+	if len(v.ends) > 0 {
+		duration = v.ends[len(v.ends)-1]
+	}
+	return nativeMeta("gif", "gif", v.g.Config.Width, v.g.Config.Height, len(v.g.Image), duration, v.size)
+}
+
+// FrameAt composites every frame up to the one shown at t, honouring each
+// frame's disposal method.
+func (v *gifVideo) FrameAt(t float64) (image.Image, error) {
+	target := frameIndexAt(v.ends, t)
+	canvas := image.NewRGBA(image.Rect(0, 0, v.g.Config.Width, v.g.Config.Height))
+
+	var previous *image.RGBA
+	for i := 0; i <= target; i++ {
+		frame := v.g.Image[i]
+		disposal := byte(gif.DisposalNone)
+		if i < len(v.g.Disposal) {
+			disposal = v.g.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewRGBA(canvas.Bounds())
+			draw.Draw(previous, previous.Bounds(), canvas, image.ZP, draw.Src)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		if i == target {
+			break
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.ZP, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return canvas, nil
+}
@@ -0,0 +1,298 @@
+// Copyright (c) 2018 Henry Slawniak <https://datacenterscumbags.com/>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"strings"
+)
+
+// mp4Codecs maps sample entry types to the names ffprobe uses.
+var mp4Codecs = map[string]string{
+	"avc1": "h264",
+	"avc3": "h264",
+	"hvc1": "hevc",
+	"hev1": "hevc",
+	"av01": "av1",
+	"vp08": "vp8",
+	"vp09": "vp9",
+	"mp4v": "mpeg4",
+	"jpeg": "mjpeg",
+	"mjpa": "mjpeg",
+	"mp4a": "aac",
+	"ac-3": "ac3",
+	"ec-3": "eac3",
+	"Opus": "opus",
+	"fLaC": "flac",
+	"tx3g": "mov_text",
+	"wvtt": "webvtt",
+}
+
+// mp4Handlers maps track handlers to ffprobe codec types.
+var mp4Handlers = map[string]string{
+	"vide": "video",
+	"soun": "audio",
+	"sbtl": "subtitle",
+	"text": "subtitle",
+	"subt": "subtitle",
+}
+
+type mp4Track struct {
+	handler   string
+	entry     string
+	width     int
+	height    int
+	timescale uint32
+	duration  uint64
+	language  string
+	samples   uint32
+	channels  int
+	rate      int
+}
+
+// mp4Video describes MP4 and QuickTime files. It can't decode them, only
+// report what is in them.
+type mp4Video struct {
+	size      int64
+	timescale uint32
+	duration  uint64
+	tracks    []*mp4Track
+}
+
+func openMP4(f *os.File, size int64) (nativeVideo, error) {
+	v := &mp4Video{size: size}
+	if err := v.parse(f, 0, size, nil); err != nil {
+		return nil, err
+	}
+	if v.timescale == 0 {
+		return nil, errors.New("mp4: missing movie header")
+	}
+	return v, nil
+}
+
+// parse walks the boxes between start and end, descending into the ones that
+// lead to track headers.
+func (v *mp4Video) parse(r io.ReaderAt, start, end int64, track *mp4Track) error {
+	header := make([]byte, 16)
+	for off := start; off+8 <= end; {
+		if _, err := r.ReadAt(header[:8], off); err != nil {
+			return fmt.Errorf("mp4: %w", err)
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		kind := string(header[4:8])
+		body := off + 8
+		switch size {
+		case 0:
+			size = end - off
+		case 1:
+			if _, err := r.ReadAt(header[8:16], off+8); err != nil {
+				return fmt.Errorf("mp4: %w", err)
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			body += 8
+		}
+		if size < body-off || size > end-off {
+			return fmt.Errorf("mp4: invalid %s box", kind)
+		}
+
+		switch kind {
+		case "moov", "mdia", "minf", "stbl":
+			if err := v.parse(r, body, off+size, track); err != nil {
+				return err
+			}
+		case "trak":
+			t := &mp4Track{}
+			v.tracks = append(v.tracks, t)
+			if err := v.parse(r, body, off+size, t); err != nil {
+				return err
+			}
+		case "mvhd", "tkhd", "mdhd", "hdlr", "stsd", "stsz":
+			// None of these are large, except stsz which we only need
+			// the start of.
+			n := off + size - body
+			if n > 256 {
+				n = 256
+			}
+			data := make([]byte, n)
+			if _, err := r.ReadAt(data, body); err != nil && err != io.EOF {
+				return fmt.Errorf("mp4: %w", err)
+			}
+			v.box(kind, data, track)
+		}
+
+		off += size
+	}
+	return nil
+}
+
+func (v *mp4Video) box(kind string, data []byte, t *mp4Track) {
+	if len(data) < 4 {
+		return
+	}
+	version := data[0]
+
+	if kind == "mvhd" {
+		v.timescale, v.duration = mp4TimeHeader(data, version)
+		return
+	}
+	if t == nil {
+		return
+	}
+
+	switch kind {
+	case "tkhd":
+		off := 76
+		if version == 1 {
+			off = 88
+		}
+		if len(data) >= off+8 {
+			t.width = int(binary.BigEndian.Uint32(data[off:]) >> 16)
+			t.height = int(binary.BigEndian.Uint32(data[off+4:]) >> 16)
+		}
+	case "mdhd":
+		t.timescale, t.duration = mp4TimeHeader(data, version)
+		off := 20
+		if version == 1 {
+			off = 32
+		}
+		if len(data) >= off+2 {
+			t.language = mp4Language(binary.BigEndian.Uint16(data[off:]))
+		}
+	case "hdlr":
+		if len(data) >= 12 {
+			t.handler = string(data[8:12])
+		}
+	case "stsd":
+		// The first sample entry starts after the entry count, with its
+		// size and type.
+		if len(data) < 16 {
+			return
+		}
+		entry := data[8:]
+		t.entry = string(entry[4:8])
+		switch t.handler {
+		case "vide":
+			if len(entry) >= 36 && t.width == 0 {
+				t.width = int(binary.BigEndian.Uint16(entry[32:]))
+				t.height = int(binary.BigEndian.Uint16(entry[34:]))
+			}
+		case "soun":
+			if len(entry) >= 36 {
+				t.channels = int(binary.BigEndian.Uint16(entry[24:]))
+				t.rate = int(binary.BigEndian.Uint32(entry[32:]) >> 16)
+			}
+		}
+	case "stsz":
+		if len(data) >= 12 {
+			t.samples = binary.BigEndian.Uint32(data[8:])
+		}
+	}
+}
+
+// mp4TimeHeader reads the timescale and duration shared by mvhd and mdhd.
+func mp4TimeHeader(data []byte, version byte) (uint32, uint64) {
+	if version == 1 {
+		if len(data) < 32 {
+			return 0, 0
+		}
+		return binary.BigEndian.Uint32(data[20:]), binary.BigEndian.Uint64(data[24:])
+	}
+	if len(data) < 20 {
+		return 0, 0
+	}
+	return binary.BigEndian.Uint32(data[12:]), uint64(binary.BigEndian.Uint32(data[16:]))
+}
+
+// mp4Language unpacks an ISO 639-2 code stored as three 5 bit letters.
+func mp4Language(packed uint16) string {
+	if packed == 0 || packed == 0x7fff {
+		return ""
+	}
+	return string([]byte{
+		byte(packed>>10&0x1f) + 0x60,
+		byte(packed>>5&0x1f) + 0x60,
+		byte(packed&0x1f) + 0x60,
+	})
+}
+
+func (v *mp4Video) Meta() *ffprobeOutput {
+	meta := &ffprobeOutput{}
+	meta.Format.FormatName = "mov,mp4,m4a,3gp,3g2,mj2"
+	meta.Format.Size = probeInt(v.size)
+	duration := float64(v.duration) / float64(v.timescale)
+	meta.Format.Duration = probeFloat(duration)
+	if duration > 0 {
+		meta.Format.BitRate = probeInt(float64(v.size*8) / duration)
+	}
+
+	for i, t := range v.tracks {
+		codecType, ok := mp4Handlers[t.handler]
+		if !ok {
+			codecType = "data"
+		}
+		codec, ok := mp4Codecs[t.entry]
+		if !ok {
+			codec = strings.TrimSpace(strings.ToLower(t.entry))
+		}
+		stream := ffprobeStreamInfo{
+			Index:      i,
+			CodecType:  codecType,
+			CodecName:  codec,
+			FrameCount: probeInt(t.samples),
+			Channels:   t.channels,
+			SampleRate: probeInt(t.rate),
+		}
+		stream.Tags.Language = t.language
+		if t.timescale > 0 {
+			stream.Duration = probeFloat(float64(t.duration) / float64(t.timescale))
+			stream.TimeBase = fmt.Sprintf("1/%d", t.timescale)
+		}
+		if codecType == "video" {
+			stream.Width = t.width
+			stream.Height = t.height
+			stream.AverageFrameRate = "0/0"
+			if t.duration > 0 {
+				stream.AverageFrameRate = fmt.Sprintf("%d/%d", uint64(t.samples)*uint64(t.timescale), t.duration)
+			}
+		}
+		meta.Streams = append(meta.Streams, stream)
+	}
+	meta.Format.NbStreams = len(meta.Streams)
+	return meta
+}
+
+func (v *mp4Video) FrameAt(t float64) (image.Image, error) {
+	for _, track := range v.tracks {
+		if track.handler == "vide" && track.entry != "" {
+			codec := track.entry
+			if name, ok := mp4Codecs[track.entry]; ok {
+				codec = name
+			}
+			return nil, fmt.Errorf("%w: MP4 with %s video", errNativeUnsupported, codec)
+		}
+	}
+	return nil, fmt.Errorf("%w: MP4", errNativeUnsupported)
+}
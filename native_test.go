@@ -0,0 +1,248 @@
+// Copyright (c) 2018 Henry Slawniak <https://datacenterscumbags.com/>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// openNativeData opens data with the built-in decoder, as if it were a file.
+func openNativeData(t *testing.T, data []byte) (nativeVideo, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "video")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return (&nativeSource{}).open(path)
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "native", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// dominant names the strongest of the red, green and blue channels in the
+// middle of img.
+func dominant(img image.Image) string {
+	b := img.Bounds()
+	r, g, bl, _ := img.At((b.Min.X+b.Max.X)/2, (b.Min.Y+b.Max.Y)/2).RGBA()
+	switch {
+	case r > 0x8000 && g < 0x4000 && bl < 0x4000:
+		return "red"
+	case g > 0x8000 && r < 0x4000 && bl < 0x4000:
+		return "green"
+	case bl > 0x8000 && r < 0x4000 && g < 0x4000:
+		return "blue"
+	}
+	return fmt.Sprintf("#%02x%02x%02x", r>>8, g>>8, bl>>8)
+}
+
+func TestNativeFixtures(t *testing.T) {
+	tests := []struct {
+		file          string
+		format, codec string
+		width, height int
+		frames        int
+		duration      float64
+		// colours are the frames shown at 0, 0.6, 1.2 and 1.9 seconds,
+		// empty when the video can't be decoded.
+		colours []string
+	}{
+		{"anim.gif", "gif", "gif", 8, 8, 3, 1.5, []string{"red", "green", "blue", "blue"}},
+		{"anim.png", "apng", "apng", 8, 8, 3, 1.5, []string{"red", "green", "blue", "blue"}},
+		// The second frame has no Huffman tables and the last is dropped.
+		{"mjpeg.avi", "avi", "mjpeg", 8, 8, 4, 2, []string{"red", "green", "blue", "blue"}},
+		{"clip.mp4", "mov,mp4,m4a,3gp,3g2,mj2", "h264", 1920, 1080, 2880, 120, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.file, func(t *testing.T) {
+			video, err := openNativeData(t, readFixture(t, tc.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			meta := video.Meta()
+			stream := meta.VideoStream()
+			if stream == nil {
+				t.Fatal("no video stream")
+			}
+			if meta.Format.FormatName != tc.format || stream.CodecName != tc.codec {
+				t.Errorf("format %s, codec %s, want %s, %s", meta.Format.FormatName, stream.CodecName, tc.format, tc.codec)
+			}
+			if stream.Width != tc.width || stream.Height != tc.height {
+				t.Errorf("size %dx%d, want %dx%d", stream.Width, stream.Height, tc.width, tc.height)
+			}
+			if int(stream.FrameCount) != tc.frames {
+				t.Errorf("%d frames, want %d", stream.FrameCount, tc.frames)
+			}
+			if duration, err := meta.DurationSeconds(); err != nil || duration != tc.duration {
+				t.Errorf("duration %v (%v), want %v", duration, err, tc.duration)
+			}
+
+			if tc.colours == nil {
+				if _, err := video.FrameAt(0); !errors.Is(err, errNativeUnsupported) {
+					t.Errorf("FrameAt = %v, want it unsupported", err)
+				}
+				return
+			}
+			for i, at := range []float64{0, 0.6, 1.2, 1.9} {
+				img, err := video.FrameAt(at)
+				if err != nil {
+					t.Errorf("FrameAt(%v): %v", at, err)
+					continue
+				}
+				if got := dominant(img); got != tc.colours[i] {
+					t.Errorf("FrameAt(%v) is %s, want %s", at, got, tc.colours[i])
+				}
+			}
+		})
+	}
+}
+
+func TestNativeCorrupt(t *testing.T) {
+	// chunk returns the offset of the data of the first chunk or box of kind.
+	chunk := func(data []byte, kind string) int {
+		i := bytes.Index(data, []byte(kind))
+		if i < 0 {
+			t.Fatalf("no %s in fixture", kind)
+		}
+		return i + 4
+	}
+	tests := []struct {
+		name    string
+		file    string
+		corrupt func(data []byte) []byte
+		// is, when set, must be wrapped by the error.
+		is error
+	}{
+		{"gif truncated", "anim.gif", func(d []byte) []byte { return d[:len(d)/2] }, nil},
+		{"gif header only", "anim.gif", func(d []byte) []byte { return d[:13] }, nil},
+		{"png truncated chunk", "anim.png", func(d []byte) []byte {
+			binary.BigEndian.PutUint32(d[8:], 0xffffffff)
+			return d
+		}, nil},
+		{"png huge canvas", "anim.png", func(d []byte) []byte {
+			binary.BigEndian.PutUint32(d[chunk(d, "IHDR"):], 1<<24)
+			return d
+		}, nil},
+		{"png frame outside canvas", "anim.png", func(d []byte) []byte {
+			binary.BigEndian.PutUint32(d[chunk(d, "fcTL")+4:], 9)
+			return d
+		}, nil},
+		{"png without animation", "anim.png", func(d []byte) []byte {
+			copy(d[chunk(d, "acTL")-4:], "abTL")
+			return d
+		}, errSkipped},
+		{"png signature only", "anim.png", func(d []byte) []byte { return d[:12] }, nil},
+		{"avi header only", "mjpeg.avi", func(d []byte) []byte { return d[:12] }, errSkipped},
+		{"avi without a frame rate", "mjpeg.avi", func(d []byte) []byte {
+			binary.LittleEndian.PutUint32(d[chunk(d, "strh")+4+24:], 0)
+			return d
+		}, errSkipped},
+		{"avi xvid", "mjpeg.avi", func(d []byte) []byte {
+			return bytes.Replace(d, []byte("MJPG"), []byte("XVID"), -1)
+		}, errNativeUnsupported},
+		{"avi nested too deeply", "mjpeg.avi", func(d []byte) []byte {
+			nested := d[:12]
+			for i := 0; i < 10; i++ {
+				nested = append(nested, "LIST\x00\x00\x00\x00hdrl"...)
+			}
+			// Each list holds the rest of the file.
+			for i := 0; i < len(nested); i += 12 {
+				binary.LittleEndian.PutUint32(nested[i+4:], uint32(len(nested)-i-8))
+			}
+			return nested
+		}, nil},
+		{"mp4 box past the end", "clip.mp4", func(d []byte) []byte {
+			binary.BigEndian.PutUint32(d[chunk(d, "moov")-8:], 0x7fffffff)
+			return d
+		}, nil},
+		{"mp4 huge 64 bit size", "clip.mp4", func(d []byte) []byte {
+			binary.BigEndian.PutUint32(d[0:], 1)
+			binary.BigEndian.PutUint64(d[8:], 1<<63-1)
+			return d
+		}, nil},
+		{"mp4 without a movie header", "clip.mp4", func(d []byte) []byte {
+			copy(d[chunk(d, "mvhd")-4:], "free")
+			return d
+		}, nil},
+		{"unknown format", "anim.gif", func(d []byte) []byte { return []byte("not a video at all") }, errNativeUnsupported},
+		{"empty", "anim.gif", func(d []byte) []byte { return nil }, errNativeUnsupported},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := openNativeData(t, tc.corrupt(readFixture(t, tc.file)))
+			if err == nil {
+				t.Fatal("opened without an error")
+			}
+			if tc.is != nil && !errors.Is(err, tc.is) {
+				t.Errorf("error %v, want %v", err, tc.is)
+			}
+		})
+	}
+}
+
+// TestNativeNoPanic opens every truncation of the fixtures, and every copy
+// with one byte inverted, decoding the first and last frames of any that
+// still open.
+func TestNativeNoPanic(t *testing.T) {
+	for _, file := range []string{"anim.gif", "anim.png", "mjpeg.avi", "clip.mp4"} {
+		fixture := readFixture(t, file)
+		path := filepath.Join(t.TempDir(), file)
+		try := func(what string, data []byte) {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("%s %s: panic: %v", file, what, r)
+				}
+			}()
+			if err := ioutil.WriteFile(path, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			video, err := (&nativeSource{}).open(path)
+			if err != nil {
+				return
+			}
+			meta := video.Meta()
+			duration, _ := meta.DurationSeconds()
+			video.FrameAt(0)
+			video.FrameAt(duration)
+		}
+
+		for n := 0; n < len(fixture); n++ {
+			try(fmt.Sprintf("truncated to %d bytes", n), fixture[:n])
+		}
+		for i := range fixture {
+			data := append([]byte(nil), fixture...)
+			data[i] ^= 0xff
+			try(fmt.Sprintf("with byte %d inverted", i), data)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright (c) 2018 Henry Slawniak <https://datacenterscumbags.com/>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/go-playground/log"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// probeRecord is a row of the probe report.
+type probeRecord struct {
+	Path     string  `json:"path"`
+	Duration float64 `json:"duration"`
+	Width    int     `json:"width"`
+	Height   int     `json:"height"`
+	Codec    string  `json:"codec"`
+	// Bitrate is in bits per second.
+	Bitrate       int64  `json:"bitrate"`
+	Size          int64  `json:"size"`
+	HashAlgorithm string `json:"hash_algorithm"`
+	Hash          string `json:"hash,omitempty"`
+}
+
+// probeSortKeys compare two records by the named column.
+var probeSortKeys = map[string]func(a, b *probeRecord) bool{
+	"path":       func(a, b *probeRecord) bool { return a.Path < b.Path },
+	"duration":   func(a, b *probeRecord) bool { return a.Duration < b.Duration },
+	"resolution": func(a, b *probeRecord) bool { return a.Width*a.Height < b.Width*b.Height },
+	"codec":      func(a, b *probeRecord) bool { return a.Codec < b.Codec },
+	"bitrate":    func(a, b *probeRecord) bool { return a.Bitrate < b.Bitrate },
+	"size":       func(a, b *probeRecord) bool { return a.Size < b.Size },
+}
+
+func runProbe(args []string) int {
+	switch *probeFormat {
+	case "table", "csv", "json":
+	default:
+		log.Errorf("Unknown format %q, use table, csv or json", *probeFormat)
+		return exitFailure
+	}
+	if _, ok := probeSortKeys[strings.TrimPrefix(*probeSort, "-")]; *probeSort != "" && !ok {
+		log.Errorf("Can't sort by %q", *probeSort)
+		return exitFailure
+	}
+
+	ctx, summary := setup()
+	inventory := &probeInventory{}
+	summary.process = inventory.probe
+	code := runJobs(ctx, summary, collectJobs(ctx, args))
+
+	inventory.sort(*probeSort)
+	if err := inventory.write(os.Stdout, *probeFormat); err != nil {
+		log.Error(err)
+		return exitFailure
+	}
+	return code
+}
+
+// probeInventory collects a record for every video probed so they can be
+// sorted and totalled once all are known.
+type probeInventory struct {
+	mu      sync.Mutex
+	records []*probeRecord
+}
+
+func (inv *probeInventory) probe(ctx context.Context, path string, opts fileOptions) error {
+	if err := checkInput(path, opts); err != nil {
+		return err
+	}
+	if *fileTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *fileTimeout)
+		defer cancel()
+	}
+
+	in, err := openInput(ctx, path)
+	if err != nil {
+		return &ProcessError{Path: path, Stage: stageOpen, Err: err}
+	}
+	defer in.Close()
+
+	video, err := probeMetadata(ctx, in, opts)
+	if err != nil {
+		return err
+	}
+	sum, err := in.hash(ctx, video.HashAlgorithm)
+	if err != nil {
+		return &ProcessError{Path: path, Stage: stageHash, Err: err}
+	}
+
+	r := &probeRecord{
+		Path:          path,
+		Duration:      video.Duration,
+		Width:         video.Width,
+		Height:        video.Height,
+		Codec:         video.Codec,
+		Bitrate:       int64(video.Meta.Format.BitRate),
+		Size:          int64(video.Meta.Format.Size),
+		HashAlgorithm: video.HashAlgorithm,
+	}
+	if sum != nil {
+		r.Hash = sum.Hex()
+	}
+	if stat, err := os.Stat(path); err == nil && r.Size == 0 {
+		r.Size = stat.Size()
+	}
+
+	inv.mu.Lock()
+	inv.records = append(inv.records, r)
+	inv.mu.Unlock()
+	return nil
+}
+
+// sort orders the records by key, descending if it starts with -. They stay
+// in the order they were probed when key is empty.
+func (inv *probeInventory) sort(key string) {
+	less, ok := probeSortKeys[strings.TrimPrefix(key, "-")]
+	if !ok {
+		return
+	}
+	descending := strings.HasPrefix(key, "-")
+	sort.SliceStable(inv.records, func(i, j int) bool {
+		if descending {
+			return less(inv.records[j], inv.records[i])
+		}
+		return less(inv.records[i], inv.records[j])
+	})
+}
+
+func (inv *probeInventory) write(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		for _, r := range inv.records {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		inv.logTotals()
+		return nil
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"path", "duration", "width", "height", "codec", "bitrate", "size", "hash_algorithm", "hash"})
+		for _, r := range inv.records {
+			cw.Write([]string{
+				r.Path,
+				strconv.FormatFloat(r.Duration, 'f', 3, 64),
+				strconv.Itoa(r.Width),
+				strconv.Itoa(r.Height),
+				r.Codec,
+				strconv.FormatInt(r.Bitrate, 10),
+				strconv.FormatInt(r.Size, 10),
+				r.HashAlgorithm,
+				r.Hash,
+			})
+		}
+		cw.Flush()
+		inv.logTotals()
+		return cw.Error()
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PATH\tDURATION\tRESOLUTION\tCODEC\tBITRATE\tSIZE\tHASH")
+	for _, r := range inv.records {
+		fmt.Fprintf(tw, "%s\t%s\t%dx%d\t%s\t%d kbps\t%s\t%s\n", r.Path, formatHours(r.Duration), r.Width, r.Height, r.Codec, r.Bitrate/1000, formatSize(r.Size), r.Hash)
+	}
+	for _, t := range inv.totals() {
+		fmt.Fprintf(tw, "%s\t%s\t\t%d videos\t\t%s\t\n", t.label, formatHours(t.duration), t.count, formatSize(t.size))
+	}
+	return tw.Flush()
+}
+
+type probeTotal struct {
+	label    string
+	count    int
+	duration float64
+	size     int64
+}
+
+// totals returns the total for every video followed by one per codec.
+func (inv *probeInventory) totals() []probeTotal {
+	all := probeTotal{label: "TOTAL"}
+	byCodec := map[string]*probeTotal{}
+	for _, r := range inv.records {
+		t, ok := byCodec[r.Codec]
+		if !ok {
+			t = &probeTotal{label: r.Codec}
+			byCodec[r.Codec] = t
+		}
+		for _, t := range []*probeTotal{&all, t} {
+			t.count++
+			t.duration += r.Duration
+			t.size += r.Size
+		}
+	}
+
+	totals := []probeTotal{all}
+	for _, t := range byCodec {
+		totals = append(totals, *t)
+	}
+	sort.Slice(totals[1:], func(i, j int) bool { return totals[i+1].size > totals[j+1].size })
+	return totals
+}
+
+// logTotals reports totals alongside machine readable output, which has no
+// room for them.
+func (inv *probeInventory) logTotals() {
+	for _, t := range inv.totals() {
+		log.Infof("%s: %d videos, %.1f hours, %d bytes", t.label, t.count, t.duration/3600, t.size)
+	}
+}
+
+// formatHours formats seconds as hours, minutes and seconds without the
+// wrapping at a day stampToString does.
+func formatHours(seconds float64) string {
+	s := int64(seconds)
+	return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+}
+
+// formatSize formats a number of bytes with a binary suffix, as parseSize
+// accepts.
+func formatSize(n int64) string {
+	const units = "KMGT"
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	f, i := float64(n)/1024, 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %ciB", f, units[i])
+}
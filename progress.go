@@ -0,0 +1,143 @@
+// Copyright (c) 2018 Henry Slawniak <https://datacenterscumbags.com/>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Progress events, emitted in roughly this order for every file.
+const (
+	eventDiscovered = "discovered"
+	eventProbing    = "probing"
+	eventFrame      = "frame"
+	eventSheet      = "sheet"
+	eventSkipped    = "skipped"
+	eventFailed     = "failed"
+)
+
+// progressEvent is a single line of machine-readable progress output.
+type progressEvent struct {
+	Event       string  `json:"event"`
+	File        string  `json:"file,omitempty"`
+	Index       int     `json:"index"`
+	Total       int     `json:"total"`
+	Frame       int     `json:"frame,omitempty"`
+	Frames      int     `json:"frames,omitempty"`
+	Output      string  `json:"output,omitempty"`
+	Stage       string  `json:"stage,omitempty"`
+	Error       string  `json:"error,omitempty"`
+	Elapsed     float64 `json:"elapsed_seconds"`
+	FileElapsed float64 `json:"file_elapsed_seconds"`
+}
+
+// progressReporter writes progressEvents as JSON lines. A nil reporter
+// discards everything, so callers never need to check whether progress
+// output was asked for.
+type progressReporter struct {
+	mu        sync.Mutex
+	w         io.Writer
+	runStart  time.Time
+	fileStart time.Time
+	file      string
+	index     int
+	total     int
+}
+
+var progress *progressReporter
+
+// setupProgress enables progress output in format on file descriptor fd.
+func setupProgress(format string, fd int) error {
+	switch format {
+	case "":
+		return nil
+	case "json":
+	default:
+		return fmt.Errorf("unknown progress format %q", format)
+	}
+
+	var w io.Writer
+	switch fd {
+	case 1:
+		w = os.Stdout
+	case 2:
+		w = os.Stderr
+	default:
+		w = os.NewFile(uintptr(fd), "progress")
+	}
+	progress = &progressReporter{w: w}
+	return nil
+}
+
+func (p *progressReporter) start() {
+	if p == nil {
+		return
+	}
+	p.runStart = time.Now()
+}
+
+// discovered announces every file that is about to be processed.
+func (p *progressReporter) discovered(paths []string) {
+	if p == nil {
+		return
+	}
+	for i, path := range paths {
+		p.beginFile(i, len(paths), path)
+		p.emit(progressEvent{Event: eventDiscovered})
+	}
+}
+
+// beginFile sets the file subsequent events refer to.
+func (p *progressReporter) beginFile(index, total int, path string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.file = path
+	p.index = index + 1
+	p.total = total
+	p.fileStart = time.Now()
+}
+
+// emit writes ev, filling in the current file and timings.
+func (p *progressReporter) emit(ev progressEvent) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	ev.File = p.file
+	ev.Index = p.index
+	ev.Total = p.total
+	ev.Elapsed = now.Sub(p.runStart).Seconds()
+	ev.FileElapsed = now.Sub(p.fileStart).Seconds()
+
+	j, _ := json.Marshal(ev)
+	p.w.Write(append(j, '\n'))
+}
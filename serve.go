@@ -0,0 +1,159 @@
+// Copyright (c) 2018 Henry Slawniak <https://datacenterscumbags.com/>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-playground/log"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+func runServe(args []string) int {
+	if len(args) != 1 || !IsDir(args[0]) {
+		log.Warn("Please provide a single directory to serve")
+		return exitFailure
+	}
+	if *sinkDest != "" {
+		log.Warn("Contact sheets are served from disk, ignoring -sink")
+		*sinkDest = ""
+	}
+	ctx, summary := setup()
+
+	s := &sheetServer{ctx: ctx, root: args[0], summary: summary}
+	srv := &http.Server{Addr: *serveAddr, Handler: s}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+
+	log.Infof("Serving %s on http://%s/", s.root, *serveAddr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Error(err)
+		return exitFailure
+	}
+	summary.print()
+	summary.Close()
+	return exitInterrupted
+}
+
+// sheetServer lists the videos below root and serves their contact sheets
+// and info JSON, generating them when they are missing or older than the
+// video.
+type sheetServer struct {
+	ctx     context.Context
+	root    string
+	summary *runSummary
+
+	// mu is held while generating, as settings are global and
+	// directory configs change them per video.
+	mu sync.Mutex
+	n  int
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<title>Thumbnailer</title>
+<ul>
+{{range .}}<li>{{.}} <a href="/sheet/{{.}}">sheet</a> <a href="/info/{{.}}">info</a></li>
+{{end}}</ul>
+`))
+
+func (s *sheetServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/":
+		s.serveIndex(w, r)
+	case strings.HasPrefix(r.URL.Path, "/sheet/"):
+		s.serveOutput(w, r, strings.TrimPrefix(r.URL.Path, "/sheet/"), ".png")
+	case strings.HasPrefix(r.URL.Path, "/info/"):
+		s.serveOutput(w, r, strings.TrimPrefix(r.URL.Path, "/info/"), ".json")
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *sheetServer) serveIndex(w http.ResponseWriter, r *http.Request) {
+	var videos []string
+	newDirWalker(r.Context(), func(p string) {
+		if rel, err := filepath.Rel(s.root, p); err == nil {
+			videos = append(videos, filepath.ToSlash(rel))
+		}
+	}).Walk(s.root)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	indexTemplate.Execute(w, videos)
+}
+
+// serveOutput serves the output with extension ext for the video at rel,
+// relative to the root.
+func (s *sheetServer) serveOutput(w http.ResponseWriter, r *http.Request, rel, ext string) {
+	path := filepath.Join(s.root, filepath.FromSlash(rel))
+	if inside, err := filepath.Rel(s.root, path); err != nil || inside == "." || strings.HasPrefix(inside, "..") {
+		http.NotFound(w, r)
+		return
+	}
+	stat, err := os.Stat(path)
+	if err != nil || stat.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	out := filepath.Join((&Video{Location: path}).GetOutputDir(), filepath.Base(path)+ext)
+	if err := s.generate(path, out, stat.ModTime()); err != nil {
+		var pe *ProcessError
+		if errors.Is(err, errSkipped) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		} else if errors.As(err, &pe) && pe.Stage == stageOpen {
+			http.Error(w, err.Error(), http.StatusForbidden)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	http.ServeFile(w, r, out)
+}
+
+// generate makes the outputs for path unless out is newer than modified.
+func (s *sheetServer) generate(path, out string, modified time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stat, err := os.Stat(out); err == nil && stat.ModTime().After(modified) {
+		return nil
+	}
+
+	s.n++
+	if err := s.summary.run(s.ctx, s.n-1, 0, fileJob{Path: path}); err != nil {
+		return err
+	}
+	if !FileExists(out) {
+		return fmt.Errorf("%s was not written, is -write-info disabled?", filepath.Base(out))
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+// Copyright (c) 2018 Henry Slawniak <https://datacenterscumbags.com/>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// outputSink is where contact sheets, info JSON and the other outputs are
+// written.
+type outputSink interface {
+	// Create starts the output that would be written to path on the local
+	// disk. It is only stored once the writer is closed.
+	Create(path string) (io.WriteCloser, error)
+	// Close finishes writing, such as the end of an archive.
+	Close() error
+}
+
+// output is the sink chosen with -sink, the local disk unless set.
+var output outputSink = localSink{}
+
+// newOutputSink returns the sink for a -sink value: empty for the local
+// disk, the path of a .tar, .tar.gz or .zip archive, or an s3:// URL.
+// Uploads are abandoned once ctx is cancelled.
+func newOutputSink(ctx context.Context, dest string) (outputSink, error) {
+	lower := strings.ToLower(dest)
+	switch {
+	case dest == "":
+		return localSink{}, nil
+	case strings.HasPrefix(lower, "s3://"):
+		return newS3Sink(ctx, dest)
+	case strings.HasSuffix(lower, ".tar"), strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return &tarSink{path: dest, compress: !strings.HasSuffix(lower, ".tar")}, nil
+	case strings.HasSuffix(lower, ".zip"):
+		return &zipSink{path: dest}, nil
+	}
+	return nil, fmt.Errorf("unknown sink %q, use an archive ending .tar, .tar.gz or .zip, or an s3:// URL", dest)
+}
+
+// writeOutput stores data as the output at path.
+func writeOutput(path string, data []byte) error {
+	w, err := output.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// outputKey names the output at path within an archive or bucket, by its
+// path relative to -o, or failing that the current directory.
+func outputKey(path string) string {
+	root := *outputDir
+	if root == "" {
+		root = "."
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	if absRoot, err := filepath.Abs(root); err == nil {
+		if rel, err := filepath.Rel(absRoot, abs); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return strings.TrimPrefix(filepath.ToSlash(abs), "/")
+}
+
+// pendingOutput holds an output in memory until it is closed, and then
+// hands it to commit.
+type pendingOutput struct {
+	bytes.Buffer
+	commit func(data []byte) error
+}
+
+func (p *pendingOutput) Close() error {
+	return p.commit(p.Bytes())
+}
+
+// localSink writes outputs to the local disk, creating directories as
+// needed.
+type localSink struct{}
+
+func (localSink) Create(path string) (io.WriteCloser, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(path)
+}
+
+func (localSink) Close() error {
+	return nil
+}
+
+// tarSink writes outputs into a tar archive, compressed with gzip if asked.
+// The archive is only created with the first output, so runs that exit
+// before writing anything don't leave an unfinished one behind.
+type tarSink struct {
+	path     string
+	compress bool
+
+	mu   sync.Mutex
+	file *os.File
+	gz   *gzip.Writer
+	tw   *tar.Writer
+}
+
+func (s *tarSink) Create(path string) (io.WriteCloser, error) {
+	name := outputKey(path)
+	return &pendingOutput{commit: func(data []byte) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.file == nil {
+			f, err := os.Create(s.path)
+			if err != nil {
+				return err
+			}
+			s.file = f
+			if s.compress {
+				s.gz = gzip.NewWriter(f)
+				s.tw = tar.NewWriter(s.gz)
+			} else {
+				s.tw = tar.NewWriter(f)
+			}
+		}
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: time.Now(),
+		}
+		if err := s.tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := s.tw.Write(data)
+		return err
+	}}, nil
+}
+
+func (s *tarSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.tw.Close()
+	if s.gz != nil {
+		if gzErr := s.gz.Close(); err == nil {
+			err = gzErr
+		}
+	}
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	s.file = nil
+	return err
+}
+
+// zipSink writes outputs into a zip archive, created with the first output
+// as for tarSink.
+type zipSink struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+	zw   *zip.Writer
+}
+
+func (s *zipSink) Create(path string) (io.WriteCloser, error) {
+	name := outputKey(path)
+	return &pendingOutput{commit: func(data []byte) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.file == nil {
+			f, err := os.Create(s.path)
+			if err != nil {
+				return err
+			}
+			s.file, s.zw = f, zip.NewWriter(f)
+		}
+		hdr := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()}
+		// Images are already compressed.
+		if ext := strings.ToLower(filepath.Ext(name)); ext == ".png" || ext == ".jpg" {
+			hdr.Method = zip.Store
+		}
+		w, err := s.zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}}, nil
+}
+
+func (s *zipSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.zw.Close()
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	s.file = nil
+	return err
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2018 Henry Slawniak <https://datacenterscumbags.com/>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/go-playground/log"
+	"image"
+	"image/draw"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+func runSprite(args []string) int {
+	// Sprites are made of small thumbnails, so there is no point
+	// extracting frames any larger.
+	*frameWidth = *tileWidth
+	ctx, summary := setup()
+	summary.process = generateSprite
+	return runJobs(ctx, summary, collectJobs(ctx, args))
+}
+
+// generateSprite writes a grid of thumbnails from the video at path to
+// NAME.sprite.png, with a WebVTT file, NAME.sprite.vtt, giving the part of
+// the grid to show for each stretch of the video.
+func generateSprite(ctx context.Context, path string, opts fileOptions) error {
+	if err := checkInput(path, opts); err != nil {
+		return err
+	}
+	if *fileTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *fileTimeout)
+		defer cancel()
+	}
+
+	in, err := openInput(ctx, path)
+	if err != nil {
+		return &ProcessError{Path: path, Stage: stageOpen, Err: err}
+	}
+	defer in.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	hashed := in.hashAsync(ctx, in.hashAlgorithm(*hashAlgorithm))
+
+	video, err := probeVideo(ctx, in, opts)
+	if err != nil {
+		return err
+	}
+
+	video.tempDir, err = ioutil.TempDir("", "thumbnailer-")
+	if err != nil {
+		return &ProcessError{Path: path, Stage: stageExtract, Err: err}
+	}
+	defer os.RemoveAll(video.tempDir)
+
+	if err := generateThumbnails(ctx, video); err != nil {
+		return &ProcessError{Path: path, Stage: stageExtract, Err: err}
+	}
+
+	// The hash is embedded in the images, so is needed before writing them.
+	sum, err := hashed()
+	if err != nil {
+		return &ProcessError{Path: path, Stage: stageHash, Err: err}
+	}
+	video.setHash(sum)
+
+	if err := writeSprite(video); err != nil {
+		return &ProcessError{Path: path, Stage: stageSheet, Err: err}
+	}
+	return nil
+}
+
+func writeSprite(vid *Video) error {
+	columns := *spriteColumns
+	if columns < 1 {
+		columns = 1
+	}
+	if len(vid.Frames) < columns {
+		columns = len(vid.Frames)
+	}
+	rows := (len(vid.Frames) + columns - 1) / columns
+
+	// Tiles of frames that failed to extract are left blank.
+	images := make([]image.Image, len(vid.Frames))
+	var tileW, tileH int
+	for i, frame := range vid.Frames {
+		if frame.Error != "" {
+			continue
+		}
+		img, err := loadFrame(vid.framePath(i))
+		if err != nil {
+			return err
+		}
+		if tileW == 0 {
+			tileW, tileH = img.Bounds().Dx(), img.Bounds().Dy()
+		}
+		images[i] = img
+	}
+	if tileW == 0 {
+		return fmt.Errorf("no frames to build a sprite from")
+	}
+
+	sprite := image.NewRGBA(image.Rect(0, 0, tileW*columns, tileH*rows))
+	for i, img := range images {
+		if img == nil {
+			continue
+		}
+		at := image.Pt((i%columns)*tileW, (i/columns)*tileH)
+		draw.Draw(sprite, image.Rectangle{at, at.Add(image.Pt(tileW, tileH))}, img, img.Bounds().Min, draw.Src)
+	}
+
+	name := vid.Filename + ".sprite.png"
+	outPath := filepath.Join(vid.GetOutputDir(), name)
+	if err := writeImage(outPath, sprite, newImageMeta(vid, vid.Frames)); err != nil {
+		return err
+	}
+
+	var vtt bytes.Buffer
+	vtt.WriteString("WEBVTT\n")
+	for i, frame := range vid.Frames {
+		start, end := frame.Time, vid.Duration
+		if i == 0 {
+			start = 0
+		}
+		if i+1 < len(vid.Frames) {
+			end = vid.Frames[i+1].Time
+		}
+		fmt.Fprintf(&vtt, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n", preciseStamp(start), preciseStamp(end), name, (i%columns)*tileW, (i/columns)*tileH, tileW, tileH)
+	}
+	if err := writeOutput(filepath.Join(vid.GetOutputDir(), vid.Filename+".sprite.vtt"), vtt.Bytes()); err != nil {
+		return err
+	}
+
+	log.Infof("Wrote %s", outPath)
+	progress.emit(progressEvent{Event: eventSheet, Output: outPath})
+	return nil
+}
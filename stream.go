@@ -0,0 +1,298 @@
+// Copyright (c) 2018 Henry Slawniak <https://datacenterscumbags.com/>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-playground/log"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// stdinPath is given in place of a path to read a video from stdin.
+const stdinPath = "-"
+
+// isURL reports whether path is an HTTP or HTTPS URL rather than a local
+// file.
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// isStream reports whether path is read over HTTP or from stdin.
+func isStream(path string) bool {
+	return path == stdinPath || isURL(path)
+}
+
+// stdinOnce guards stdin, which can only be read once per run.
+var stdinOnce sync.Once
+
+// claimStdin fails if stdin has already been read, for a file list or
+// another video.
+func claimStdin() error {
+	used := true
+	stdinOnce.Do(func() { used = false })
+	if used {
+		return errors.New("stdin has already been read")
+	}
+	return nil
+}
+
+// input is a video about to be processed, which may be a local file, or
+// streamed over HTTP or from stdin.
+type input struct {
+	// path is as it was given.
+	path string
+	// name is what the outputs are named after.
+	name string
+	// location is where the frame source reads the video from: the file,
+	// the URL when the server supports range requests, or otherwise a
+	// copy spooled to a temporary file.
+	location string
+	spool    string
+	// size is the length of the video, when it is read with range
+	// requests.
+	size int64
+	// sum was computed with algo while spooling.
+	sum  hashsum
+	algo string
+}
+
+// openInput prepares the video at path to be read. Remote videos that
+// can't be seeked in, and videos on stdin, are copied to a temporary file,
+// and hashed on the way, which Close removes.
+func openInput(ctx context.Context, path string) (*input, error) {
+	in := &input{path: path, name: filepath.Base(path), location: path}
+	var err error
+	switch {
+	case path == stdinPath:
+		in.name = "stdin"
+		if err := claimStdin(); err != nil {
+			return nil, err
+		}
+		err = in.spoolFrom(ctx, os.Stdin, "")
+	case isURL(path):
+		err = in.openURL(ctx)
+	}
+	if err != nil {
+		// Removes whatever was spooled before the copy failed.
+		in.Close()
+		return nil, err
+	}
+	return in, nil
+}
+
+// openURL checks whether the server supports range requests by asking for
+// the first byte. If it does, ffmpeg reads the video from the server itself,
+// otherwise the whole video is spooled.
+func (in *input) openURL(ctx context.Context) error {
+	u, err := url.Parse(in.path)
+	if err != nil {
+		return err
+	}
+	in.name = path.Base(u.Path)
+	if in.name == "/" || in.name == "." {
+		in.name = u.Host
+	}
+
+	resp, err := httpGet(ctx, in.path, "bytes=0-0")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusPartialContent {
+		in.size = contentRangeSize(resp.Header.Get("Content-Range"))
+		if in.size > 0 && source.Name() == backendFFMpeg {
+			log.Debugf("%s supports range requests, reading it in place", in.path)
+			return nil
+		}
+		// The native backend only reads local files.
+		resp.Body.Close()
+		if resp, err = httpGet(ctx, in.path, ""); err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+	} else {
+		log.Infof("%s doesn't support range requests, downloading it", in.path)
+	}
+	return in.spoolFrom(ctx, resp.Body, path.Ext(u.Path))
+}
+
+// spoolFrom copies r to a temporary file with extension ext, so that the
+// frame source can seek in it, hashing it on the way.
+func (in *input) spoolFrom(ctx context.Context, r io.Reader, ext string) error {
+	f, err := ioutil.TempFile("", "thumbnailer-spool-*"+ext)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	in.spool, in.location = f.Name(), f.Name()
+
+	w := io.Writer(f)
+	var h hash.Hash
+	if *hashAlgorithm != hashNone && *hashAlgorithm != hashPartial {
+		h = newHash(*hashAlgorithm)
+		w = io.MultiWriter(f, h)
+	}
+	if _, err := io.Copy(w, &contextReader{ctx: ctx, r: r}); err != nil {
+		return err
+	}
+	if h != nil {
+		in.sum, in.algo = h.Sum(nil), *hashAlgorithm
+	}
+	return f.Close()
+}
+
+// hashAlgorithm returns the algorithm in is hashed with, given -hash. ffmpeg
+// reads videos on servers supporting range requests itself, so hashing all
+// of one means downloading it a second time. Unless -hash was chosen they
+// get the partial hash instead, which reads a few chunks with range
+// requests.
+func (in *input) hashAlgorithm(algo string) string {
+	if isURL(in.location) && algo != hashNone && config.source["hash"] == "default" {
+		return hashPartial
+	}
+	return algo
+}
+
+// hashAsync hashes the video with algo alongside whatever the caller does
+// next, as hashing a large file can take as long as everything else put
+// together. The returned function waits for the result.
+func (in *input) hashAsync(ctx context.Context, algo string) func() (hashsum, error) {
+	type hashResult struct {
+		sum hashsum
+		err error
+	}
+	hashed := make(chan hashResult, 1)
+	go func() {
+		sum, err := in.hash(ctx, algo)
+		hashed <- hashResult{sum, err}
+	}()
+	return func() (hashsum, error) {
+		h := <-hashed
+		return h.sum, h.err
+	}
+}
+
+// hash hashes the video with algo, reusing the hash computed while spooling
+// if there is one. Remote videos are hashed with range requests for the
+// partial hash, and otherwise downloaded again.
+func (in *input) hash(ctx context.Context, algo string) (hashsum, error) {
+	if in.sum != nil && in.algo == algo {
+		return in.sum, nil
+	}
+	if !isURL(in.location) || algo == hashNone {
+		return hashFile(ctx, in.location, algo)
+	}
+
+	h := newHash(algo)
+	if algo == hashPartial {
+		r := &rangeReader{ctx: ctx, url: in.location}
+		if err := partialHash(ctx, h, r, in.size); err != nil {
+			return nil, err
+		}
+		return h.Sum(nil), nil
+	}
+	resp, err := httpGet(ctx, in.location, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(h, &contextReader{ctx: ctx, r: resp.Body}); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// Close removes the spooled copy of the video, if there is one.
+func (in *input) Close() error {
+	if in.spool == "" {
+		return nil
+	}
+	return os.Remove(in.spool)
+}
+
+// httpGet requests url, or the byte range given in the form of a Range
+// header, failing on any unsuccessful status.
+func httpGet(ctx context.Context, url, byteRange string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if byteRange != "" {
+		req.Header.Set("Range", byteRange)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return resp, nil
+}
+
+// contentRangeSize returns the complete length given in a Content-Range
+// header such as "bytes 0-0/1234", or 0 if it is unknown.
+func contentRangeSize(header string) int64 {
+	i := strings.LastIndex(header, "/")
+	if i < 0 {
+		return 0
+	}
+	size, err := strconv.ParseInt(header[i+1:], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return size
+}
+
+// rangeReader reads parts of a remote file with range requests.
+type rangeReader struct {
+	ctx context.Context
+	url string
+}
+
+func (r *rangeReader) ReadAt(p []byte, off int64) (int, error) {
+	resp, err := httpGet(r.ctx, r.url, fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("%s: range request ignored", r.url)
+	}
+	n, err := io.ReadFull(resp.Body, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,210 @@
+// Copyright (c) 2018 Henry Slawniak <https://datacenterscumbags.com/>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testVideo is served as a video in these tests, long enough for the
+// partial hash to sample rather than read all of it.
+var testVideo = bytes.Repeat([]byte("0123456789abcdef"), partialSamples*partialChunkSize/8)
+
+func serveVideo(ranges bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ranges {
+			http.ServeContent(w, r, "movie.mkv", time.Time{}, bytes.NewReader(testVideo))
+			return
+		}
+		w.Write(testVideo)
+	}))
+}
+
+func TestContentRangeSize(t *testing.T) {
+	tests := map[string]int64{
+		"bytes 0-0/1234": 1234,
+		"bytes 10-19/20": 20,
+		"bytes 0-0/*":    0,
+		"":               0,
+		"bytes 0-0/abc":  0,
+	}
+	for header, want := range tests {
+		if got := contentRangeSize(header); got != want {
+			t.Errorf("contentRangeSize(%q) = %d, want %d", header, got, want)
+		}
+	}
+}
+
+func TestRangeReaderReadAt(t *testing.T) {
+	srv := serveVideo(true)
+	defer srv.Close()
+	r := &rangeReader{ctx: context.Background(), url: srv.URL + "/movie.mkv"}
+
+	buf := make([]byte, 100)
+	n, err := r.ReadAt(buf, 1000)
+	if err != nil || n != len(buf) {
+		t.Fatalf("ReadAt = %d, %v", n, err)
+	}
+	if !bytes.Equal(buf, testVideo[1000:1100]) {
+		t.Errorf("ReadAt read the wrong bytes")
+	}
+
+	off := int64(len(testVideo) - 10)
+	n, err = r.ReadAt(buf, off)
+	if err != io.EOF || n != 10 {
+		t.Errorf("ReadAt at the end = %d, %v, want 10, EOF", n, err)
+	}
+	if !bytes.Equal(buf[:n], testVideo[off:]) {
+		t.Errorf("ReadAt at the end read the wrong bytes")
+	}
+}
+
+func TestRangeReaderIgnoredRange(t *testing.T) {
+	srv := serveVideo(false)
+	defer srv.Close()
+	r := &rangeReader{ctx: context.Background(), url: srv.URL + "/movie.mkv"}
+	if _, err := r.ReadAt(make([]byte, 10), 100); err == nil {
+		t.Errorf("ReadAt succeeded when the server ignored the range")
+	}
+}
+
+func TestOpenInputWithRanges(t *testing.T) {
+	srv := serveVideo(true)
+	defer srv.Close()
+	previous := source
+	t.Cleanup(func() { source = previous })
+	source = ffmpegSource{}
+
+	url := srv.URL + "/videos/movie.mkv"
+	in, err := openInput(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+
+	if in.location != url || in.spool != "" {
+		t.Errorf("location = %q, spool = %q, want the URL read in place", in.location, in.spool)
+	}
+	if in.name != "movie.mkv" {
+		t.Errorf("name = %q, want movie.mkv", in.name)
+	}
+	if in.size != int64(len(testVideo)) {
+		t.Errorf("size = %d, want %d", in.size, len(testVideo))
+	}
+
+	got, err := in.hash(context.Background(), hashPartial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := localHash(t, hashPartial)
+	if got.Hex() != want.Hex() {
+		t.Errorf("partial hash over HTTP = %s, want %s", got.Hex(), want.Hex())
+	}
+}
+
+func TestOpenInputSpools(t *testing.T) {
+	srv := serveVideo(false)
+	defer srv.Close()
+	previous := *hashAlgorithm
+	t.Cleanup(func() { *hashAlgorithm = previous })
+	*hashAlgorithm = hashSHA1
+
+	in, err := openInput(context.Background(), srv.URL+"/movie.mkv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in.spool == "" || in.location != in.spool {
+		t.Fatalf("location = %q, spool = %q, want a spooled copy", in.location, in.spool)
+	}
+	if filepath.Ext(in.spool) != ".mkv" {
+		t.Errorf("spool %s doesn't keep the extension", in.spool)
+	}
+	data, err := ioutil.ReadFile(in.spool)
+	if err != nil || !bytes.Equal(data, testVideo) {
+		t.Errorf("spooled copy differs from the video: %v", err)
+	}
+
+	sum := sha1.Sum(testVideo)
+	got, err := in.hash(context.Background(), hashSHA1)
+	if err != nil || !bytes.Equal(got, sum[:]) {
+		t.Errorf("hash = %x, %v, want %x", got, err, sum)
+	}
+	if in.hashAlgorithm(hashSHA1) != hashSHA1 {
+		t.Errorf("spooled videos should keep the full hash")
+	}
+
+	if err := in.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(in.spool); !os.IsNotExist(err) {
+		t.Errorf("Close left the spooled copy behind")
+	}
+}
+
+func TestOpenInputFailedDownload(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(testVideo)))
+		w.Write(testVideo[:1000])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+
+	if in, err := openInput(context.Background(), srv.URL+"/movie.mkv"); err == nil {
+		in.Close()
+		t.Fatal("openInput succeeded when the download was cut short")
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		t.Errorf("%s was left behind", f.Name())
+	}
+}
+
+func localHash(t *testing.T, algo string) hashsum {
+	f, err := ioutil.TempFile("", "thumbnailer-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write(testVideo)
+	f.Close()
+	sum, err := hashFile(context.Background(), f.Name(), algo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sum
+}
@@ -0,0 +1,343 @@
+// Copyright (c) 2018 Henry Slawniak <https://datacenterscumbags.com/>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Supported values for -subtitles.
+const (
+	subtitlesBurn    = "burn"
+	subtitlesCaption = "caption"
+)
+
+// SubtitleExtensions are the sidecar subtitle formats we understand, in order
+// of preference.
+var SubtitleExtensions = []string{".srt", ".ass", ".ssa", ".vtt"}
+
+// textSubtitleCodecs are the embedded subtitle codecs that can be converted to
+// text, unlike bitmap formats such as PGS or VobSub.
+var textSubtitleCodecs = map[string]bool{
+	"subrip":   true,
+	"srt":      true,
+	"ass":      true,
+	"ssa":      true,
+	"webvtt":   true,
+	"mov_text": true,
+	"text":     true,
+}
+
+// subtitleSource is where the subtitles for a video come from, either a
+// sidecar file or one of the video's own subtitle streams.
+type subtitleSource struct {
+	// Path is the sidecar file, empty for embedded subtitles.
+	Path string `json:",omitempty"`
+	// Stream is the index among the video's subtitle streams.
+	Stream   int
+	Language string `json:",omitempty"`
+	Codec    string `json:",omitempty"`
+}
+
+// subtitleCue is a single piece of subtitle text and when it is shown.
+type subtitleCue struct {
+	Start float64
+	End   float64
+	Text  string
+}
+
+// attachSubtitles finds the subtitles for vid and, when they are to be drawn
+// as captions, the text shown at each frame.
+func attachSubtitles(ctx context.Context, vid *Video) error {
+	sub, err := findSubtitles(vid, *subtitleFile, *subtitleLang)
+	if err != nil {
+		return err
+	}
+
+	if *subtitleMode == subtitlesCaption {
+		cues, err := sub.loadCues(ctx, vid)
+		if err != nil {
+			return err
+		}
+		for i := range vid.Frames {
+			vid.Frames[i].Subtitle = cueAt(cues, vid.Frames[i].Time)
+		}
+	}
+
+	vid.Subtitles = sub
+	return nil
+}
+
+// findSubtitles picks the subtitles to use for vid. An explicit -subtitle-file
+// wins, then sidecar files next to the video, then embedded streams, each
+// filtered by lang when it isn't empty.
+func findSubtitles(vid *Video, file, lang string) (*subtitleSource, error) {
+	if file != "" {
+		if !FileExists(file) {
+			return nil, fmt.Errorf("subtitle file %s does not exist", file)
+		}
+		return &subtitleSource{Path: file, Language: lang}, nil
+	}
+
+	base := strings.TrimSuffix(vid.Location, filepath.Ext(vid.Location))
+	for _, ext := range SubtitleExtensions {
+		candidates := []string{base + ext}
+		if lang != "" {
+			candidates = []string{base + "." + lang + ext}
+		}
+		for _, c := range candidates {
+			if FileExists(c) {
+				return &subtitleSource{Path: c, Language: lang}, nil
+			}
+		}
+	}
+
+	// Bitmap streams can neither be burned in by the subtitles filter nor
+	// read as text, so only text streams are considered.
+	n := 0
+	var bitmap string
+	for _, stream := range vid.Meta.Streams {
+		if stream.CodecType != "subtitle" {
+			continue
+		}
+		if lang == "" || strings.EqualFold(stream.Tags.Language, lang) {
+			if textSubtitleCodecs[stream.CodecName] {
+				return &subtitleSource{
+					Stream:   n,
+					Language: stream.Tags.Language,
+					Codec:    stream.CodecName,
+				}, nil
+			}
+			if bitmap == "" {
+				bitmap = stream.CodecName
+			}
+		}
+		n++
+	}
+
+	if bitmap != "" {
+		return nil, fmt.Errorf("%s subtitles are images, only text subtitles are supported", bitmap)
+	}
+	if lang != "" {
+		return nil, fmt.Errorf("no %s subtitles found", lang)
+	}
+	return nil, fmt.Errorf("no subtitles found")
+}
+
+// burnFilter returns the ffmpeg filter rendering sub onto frames of vid. The
+// frames must keep their original timestamps, see -copyts.
+func (sub *subtitleSource) burnFilter(vid *Video) string {
+	if sub.Path != "" {
+		return "subtitles=filename=" + escapeFilterValue(sub.Path)
+	}
+	return fmt.Sprintf("subtitles=filename=%s:si=%d", escapeFilterValue(vid.Location), sub.Stream)
+}
+
+// escapeFilterValue escapes s for use as an option value inside a filtergraph,
+// which ffmpeg unescapes twice.
+func escapeFilterValue(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`).Replace(s)
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`, `,`, `\,`, `;`, `\;`).Replace(s)
+}
+
+// loadCues reads the cues of sub, converting embedded subtitles to SubRip
+// with ffmpeg.
+func (sub *subtitleSource) loadCues(ctx context.Context, vid *Video) ([]subtitleCue, error) {
+	if sub.Path != "" {
+		f, err := os.Open(sub.Path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		switch strings.ToLower(filepath.Ext(sub.Path)) {
+		case ".ass", ".ssa":
+			return parseASS(f)
+		default:
+			// WebVTT cues are close enough to SubRip to share a parser.
+			return parseSRT(f)
+		}
+	}
+
+	out, err := runCommand(
+		ctx,
+		GetFFMpegBinary(),
+		"-v", "error",
+		"-i", vid.Location,
+		"-map", fmt.Sprintf("0:s:%d", sub.Stream),
+		"-f", "srt",
+		"-",
+	)
+	if err != nil {
+		return nil, err
+	}
+	return parseSRT(bytes.NewReader(out))
+}
+
+// cueAt returns the text of the cue shown at t, if any.
+func cueAt(cues []subtitleCue, t float64) string {
+	var lines []string
+	for _, c := range cues {
+		if t >= c.Start && t < c.End {
+			lines = append(lines, c.Text)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+var (
+	srtTiming = regexp.MustCompile(`^\s*((?:\d+:)?\d+:\d+[,.]\d+)\s*-->\s*((?:\d+:)?\d+:\d+[,.]\d+)`)
+	markupTag = regexp.MustCompile(`<[^>]*>|\{\\[^}]*\}`)
+)
+
+// parseSRT parses SubRip, and WebVTT, cues.
+func parseSRT(r io.Reader) ([]subtitleCue, error) {
+	var cues []subtitleCue
+	var cur *subtitleCue
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		line = strings.TrimPrefix(line, "\ufeff")
+
+		if m := srtTiming.FindStringSubmatch(line); m != nil {
+			start, err := parseSubtitleTime(m[1])
+			if err != nil {
+				return nil, err
+			}
+			end, err := parseSubtitleTime(m[2])
+			if err != nil {
+				return nil, err
+			}
+			cues = append(cues, subtitleCue{Start: start, End: end})
+			cur = &cues[len(cues)-1]
+			continue
+		}
+
+		if strings.TrimSpace(line) == "" {
+			cur = nil
+			continue
+		}
+		if cur == nil {
+			// Cue numbers, identifiers and the WebVTT header.
+			continue
+		}
+		text := cleanSubtitleText(line)
+		if cur.Text != "" {
+			cur.Text += "\n"
+		}
+		cur.Text += text
+	}
+	return cues, scanner.Err()
+}
+
+// parseASS parses the Dialogue lines of an Advanced SubStation Alpha script.
+func parseASS(r io.Reader) ([]subtitleCue, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// The default field order, overridden by the Format line of [Events].
+	fields := []string{"layer", "start", "end", "style", "name", "marginl", "marginr", "marginv", "effect", "text"}
+	inEvents := false
+
+	var cues []subtitleCue
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(strings.TrimPrefix(line, "\ufeff"))
+		if strings.HasPrefix(line, "[") {
+			inEvents = strings.EqualFold(line, "[Events]")
+			continue
+		}
+		if !inEvents {
+			continue
+		}
+
+		key, value := splitASSLine(line)
+		switch strings.ToLower(key) {
+		case "format":
+			fields = nil
+			for _, f := range strings.Split(value, ",") {
+				fields = append(fields, strings.ToLower(strings.TrimSpace(f)))
+			}
+		case "dialogue":
+			values := strings.SplitN(value, ",", len(fields))
+			if len(values) != len(fields) {
+				continue
+			}
+			cue := subtitleCue{}
+			for i, f := range fields {
+				v := strings.TrimSpace(values[i])
+				switch f {
+				case "start":
+					cue.Start, err = parseSubtitleTime(v)
+				case "end":
+					cue.End, err = parseSubtitleTime(v)
+				case "text":
+					v = strings.NewReplacer(`\N`, "\n", `\n`, "\n", `\h`, " ").Replace(v)
+					cue.Text = cleanSubtitleText(v)
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+			cues = append(cues, cue)
+		}
+	}
+	return cues, nil
+}
+
+func splitASSLine(line string) (string, string) {
+	i := strings.Index(line, ":")
+	if i < 0 {
+		return "", ""
+	}
+	return strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+}
+
+// parseSubtitleTime parses [HH:]MM:SS[,.]fff timestamps.
+func parseSubtitleTime(s string) (float64, error) {
+	s = strings.Replace(s, ",", ".", 1)
+	parts := strings.Split(s, ":")
+	var t float64
+	for _, p := range parts {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid subtitle timestamp %q", s)
+		}
+		t = t*60 + v
+	}
+	return t, nil
+}
+
+func cleanSubtitleText(s string) string {
+	return strings.TrimSpace(markupTag.ReplaceAllString(s, ""))
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2018 Henry Slawniak <https://datacenterscumbags.com/>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFindSubtitlesSkipsBitmaps(t *testing.T) {
+	stream := func(codecType, codec, lang string) ffprobeStreamInfo {
+		return ffprobeStreamInfo{CodecType: codecType, CodecName: codec, Tags: ffprobeTags{Language: lang}}
+	}
+	vid := &Video{Location: "/nonexistent/movie.mkv", Meta: &ffprobeOutput{Streams: []ffprobeStreamInfo{
+		stream("video", "h264", ""),
+		stream("subtitle", "hdmv_pgs_subtitle", "eng"),
+		stream("subtitle", "dvd_subtitle", "fre"),
+		stream("subtitle", "subrip", "fre"),
+		stream("subtitle", "ass", "eng"),
+	}}}
+
+	for _, tc := range []struct {
+		lang   string
+		stream int
+		codec  string
+	}{
+		{"", 2, "subrip"},
+		{"eng", 3, "ass"},
+		{"fre", 2, "subrip"},
+	} {
+		sub, err := findSubtitles(vid, "", tc.lang)
+		if err != nil {
+			t.Errorf("findSubtitles(%q): %v", tc.lang, err)
+			continue
+		}
+		if sub.Stream != tc.stream || sub.Codec != tc.codec {
+			t.Errorf("findSubtitles(%q) = stream %d (%s), want %d (%s)", tc.lang, sub.Stream, sub.Codec, tc.stream, tc.codec)
+		}
+	}
+
+	vid.Meta.Streams = vid.Meta.Streams[:3]
+	if _, err := findSubtitles(vid, "", ""); err == nil || !strings.Contains(err.Error(), "hdmv_pgs_subtitle") {
+		t.Errorf("findSubtitles with only bitmap streams = %v, want an error naming the codec", err)
+	}
+}
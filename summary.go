@@ -0,0 +1,195 @@
+// Copyright (c) 2018 Henry Slawniak <https://datacenterscumbags.com/>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-playground/log"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Exit codes, so scripts can tell a partially failed batch from one where
+// nothing worked.
+const (
+	exitSuccess     = 0
+	exitFailure     = 1
+	exitPartial     = 2
+	exitInterrupted = 130
+)
+
+type fileStatus string
+
+const (
+	statusCompleted   fileStatus = "completed"
+	statusSkipped     fileStatus = "skipped"
+	statusFailed      fileStatus = "failed"
+	statusInterrupted fileStatus = "interrupted"
+)
+
+// fileReport is written to the report file, one JSON object per line, for
+// every file we were asked to process.
+type fileReport struct {
+	Path    string     `json:"path"`
+	Status  fileStatus `json:"status"`
+	Stage   string     `json:"stage,omitempty"`
+	Error   string     `json:"error,omitempty"`
+	Stderr  string     `json:"stderr,omitempty"`
+	Elapsed float64    `json:"elapsed_seconds"`
+}
+
+// runSummary keeps track of what happened to every file we were asked to
+// process so it can be reported once the run is over.
+type runSummary struct {
+	completed   []string
+	skipped     []string
+	failed      []string
+	interrupted []string
+
+	// process does the work for each file, ProcessFile unless a command
+	// says otherwise.
+	process func(ctx context.Context, path string, opts fileOptions) error
+
+	report io.WriteCloser
+}
+
+// newRunSummary returns a summary that also writes a JSON line per file to
+// reportPath, unless it is empty.
+func newRunSummary(reportPath string) (*runSummary, error) {
+	s := &runSummary{process: ProcessFile}
+	switch reportPath {
+	case "":
+	case "-":
+		s.report = os.Stdout
+	default:
+		f, err := os.Create(reportPath)
+		if err != nil {
+			return nil, err
+		}
+		s.report = f
+	}
+	return s, nil
+}
+
+// run processes job, the index'th of total files, and records the outcome.
+func (s *runSummary) run(ctx context.Context, index, total int, job fileJob) error {
+	start := time.Now()
+	progress.beginFile(index, total, job.Path)
+
+	restore, err := config.apply(filepath.Dir(job.Path))
+	if err != nil {
+		err = &ProcessError{Path: job.Path, Stage: stageOpen, Err: err}
+		s.record(job.Path, err, time.Since(start))
+		return err
+	}
+	defer restore()
+
+	err = s.process(ctx, job.Path, job.fileOptions)
+	s.record(job.Path, err, time.Since(start))
+	return err
+}
+
+func (s *runSummary) record(path string, err error, elapsed time.Duration) {
+	status := statusCompleted
+	switch {
+	case err == nil:
+	case errors.Is(err, errSkipped):
+		status = statusSkipped
+		log.Infof("Skipping %s", err)
+		progress.emit(progressEvent{Event: eventSkipped, Error: err.Error()})
+	case errors.Is(err, context.Canceled):
+		status = statusInterrupted
+	default:
+		status = statusFailed
+		log.Error(err)
+		progress.emit(progressEvent{Event: eventFailed, Stage: errorStage(err), Error: err.Error()})
+	}
+
+	switch status {
+	case statusCompleted:
+		s.completed = append(s.completed, path)
+	case statusSkipped:
+		s.skipped = append(s.skipped, path)
+	case statusFailed:
+		s.failed = append(s.failed, path)
+	case statusInterrupted:
+		s.interrupted = append(s.interrupted, path)
+	}
+
+	if s.report == nil {
+		return
+	}
+	r := fileReport{
+		Path:    path,
+		Status:  status,
+		Elapsed: elapsed.Seconds(),
+	}
+	if err != nil {
+		r.Stage = errorStage(err)
+		r.Error = err.Error()
+		r.Stderr = errorStderr(err)
+	}
+	j, _ := json.Marshal(r)
+	if _, err := s.report.Write(append(j, '\n')); err != nil {
+		log.Errorf("Cannot write report: %s", err)
+	}
+}
+
+func (s *runSummary) print() {
+	log.Infof("Finished %d, skipped %d, failed %d", len(s.completed), len(s.skipped), len(s.failed))
+	for _, p := range s.completed {
+		log.Infof("Finished: %s", p)
+	}
+	for _, p := range s.failed {
+		log.Warnf("Failed: %s", p)
+	}
+	for _, p := range s.interrupted {
+		log.Warnf("Interrupted: %s", p)
+	}
+}
+
+// exitCode returns the process exit code describing the run.
+func (s *runSummary) exitCode() int {
+	switch {
+	case len(s.interrupted) > 0:
+		return exitInterrupted
+	case len(s.failed) == 0:
+		return exitSuccess
+	case len(s.completed) == 0:
+		return exitFailure
+	default:
+		return exitPartial
+	}
+}
+
+// Close closes the report file, if any.
+func (s *runSummary) Close() error {
+	if s.report == nil || s.report == os.Stdout {
+		return nil
+	}
+	err := s.report.Close()
+	s.report = nil
+	return err
+}
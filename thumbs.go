@@ -21,37 +21,142 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/go-playground/log"
-	"os"
-	"os/exec"
-	"path/filepath"
-	"runtime/debug"
+	"math"
+	"regexp"
+	"strconv"
 )
 
-func generateThumbnails(vid *Video) {
-	binary := GetFFMpegBinary()
+// retryOffset is how many seconds before a frame that failed to extract
+// another attempt is made, as failures are usually frames at the very end.
+const retryOffset = 1.0
 
+// generateThumbnails extracts every frame of vid into its temporary
+// directory. Each ffmpeg invocation is bounded by the frame timeout and is
+// killed as soon as ctx is cancelled. A frame that cannot be extracted is
+// tried again a little earlier, and if that fails too its Error is set and
+// the rest are carried on with. A *FrameError is returned only when no frame
+// could be extracted.
+func generateThumbnails(ctx context.Context, vid *Video) error {
 	if *frameWidth == 0 {
 		*frameWidth = vid.Width
 	}
-	for i := 0; i < vid.ThumbCount; i++ {
-		cmd := exec.Command(
-			binary, "-n",
-			"-ss", fmt.Sprintf("%f", vid.Step*float64(i)),
-			"-i", vid.Location,
-			"-vframes", "1",
-			"-vf", fmt.Sprintf("scale=%d:-1:", *frameWidth),
-			filepath.Join(os.TempDir(), fmt.Sprintf("%s-%d.png", vid.SHA1.Hex(), i)),
-		)
-
-		// cmd.Stdout = os.Stdout
-		// cmd.Stderr = os.Stderr
-
-		err := cmd.Run()
+	var first error
+	failed := 0
+	for i, frame := range vid.Frames {
+		if frame.Error != "" {
+			failed++
+			continue
+		}
+		t, err := extractWithTimeout(ctx, vid, frame)
+		if errors.Is(err, errSkipped) || errors.Is(err, errNativeUnsupported) {
+			// The whole video can't be decoded, there's no point trying
+			// the other frames.
+			return err
+		}
+		if err != nil && ctx.Err() == nil && frame.Time > 0 {
+			retry := frame
+			retry.Time = math.Max(frame.Time-retryOffset, 0)
+			log.Debugf("Frame %d of %s at %s failed, trying %s", i, vid.Filename, preciseStamp(frame.Time), preciseStamp(retry.Time))
+			if t, err = extractWithTimeout(ctx, vid, retry); err == nil && vid.Frames[i].Target == 0 {
+				vid.Frames[i].Target = frame.Time
+			}
+		}
 		if err != nil {
-			debug.PrintStack()
-			log.Error(err.Error())
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Warnf("Leaving out frame %d of %s at %s: %s", i, vid.Filename, stampToString(frame.Time), err)
+			vid.Frames[i].Error = err.Error()
+			if first == nil {
+				first = &FrameError{Index: i, Time: frame.Time, Err: err}
+			}
+			failed++
+			continue
+		}
+		if t != frame.Time {
+			if vid.Frames[i].Target == 0 {
+				vid.Frames[i].Target = frame.Time
+			}
+			vid.Frames[i].Time = t
 		}
+		progress.emit(progressEvent{Event: eventFrame, Frame: i + 1, Frames: len(vid.Frames)})
+	}
+	if failed == len(vid.Frames) && first != nil {
+		return first
 	}
+	return nil
 }
+
+func extractWithTimeout(ctx context.Context, vid *Video, frame Frame) (float64, error) {
+	if *frameTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *frameTimeout)
+		defer cancel()
+	}
+	return source.ExtractFrame(ctx, vid, frame)
+}
+
+// extractFrame has ffmpeg write a single frame of vid, returning its time.
+// In accurate mode ffmpeg seeks to the keyframe before the frame, decodes up
+// to the exact time, and reports the timestamp of the frame it wrote.
+func extractFrame(ctx context.Context, binary string, vid *Video, frame Frame) (float64, error) {
+	filter := fmt.Sprintf("scale=%d:-1:", vid.thumbWidth())
+	args := []string{"-n"}
+	if *accurateSeek {
+		args = append(args, "-noaccurate_seek")
+	}
+	args = append(args, "-ss", fmt.Sprintf("%f", frame.Time))
+
+	copyTS := *accurateSeek
+	if vid.Subtitles != nil && *subtitleMode == subtitlesBurn {
+		// Keep the original timestamps so the subtitles filter renders the
+		// cue shown at the frame rather than at the start of the video.
+		copyTS = true
+		filter = vid.Subtitles.burnFilter(vid) + "," + filter
+	}
+	if *accurateSeek {
+		// With -copyts timestamps include the container's start time.
+		start := float64(vid.Meta.Format.StartTime)
+		filter = fmt.Sprintf("select='gte(t\\,%f)',%s,showinfo", frame.Time+start, filter)
+	}
+	if copyTS {
+		args = append(args, "-copyts")
+	}
+	args = append(args,
+		"-i", vid.Location,
+		"-vframes", "1",
+		"-vf", filter,
+		vid.framePath(frame.Index),
+	)
+
+	_, stderr, err := runCommandStderr(ctx, binary, args...)
+	if err != nil {
+		return frame.Time, err
+	}
+	// ffmpeg succeeds without writing anything when there's no frame at
+	// or after the time it was asked for.
+	if !FileExists(vid.framePath(frame.Index)) {
+		return frame.Time, fmt.Errorf("no frame at %s", preciseStamp(frame.Time))
+	}
+	if !*accurateSeek {
+		return frame.Time, nil
+	}
+
+	m := showinfoPTS.FindSubmatch(stderr)
+	if m == nil {
+		return frame.Time, fmt.Errorf("ffmpeg did not report the time of the frame")
+	}
+	pts, err := strconv.ParseFloat(string(m[1]), 64)
+	if err != nil {
+		return frame.Time, err
+	}
+	return pts - float64(vid.Meta.Format.StartTime), nil
+}
+
+// showinfoPTS finds the presentation time of a frame in the output of
+// ffmpeg's showinfo filter.
+var showinfoPTS = regexp.MustCompile(`pts_time:\s*(-?[0-9.]+(?:e[-+]?[0-9]+)?)`)
@@ -0,0 +1,89 @@
+// Copyright (c) 2018 Henry Slawniak <https://datacenterscumbags.com/>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+func runVerify(args []string) int {
+	ctx, summary := setup()
+	summary.process = verifyFile
+	return runJobs(ctx, summary, collectJobs(ctx, args))
+}
+
+// verifyFile checks the video at path has a contact sheet and info JSON, and
+// that its hash still matches the one recorded in the info JSON.
+func verifyFile(ctx context.Context, path string, opts fileOptions) error {
+	if err := checkInput(path, opts); err != nil {
+		return err
+	}
+	if isStream(path) {
+		return &ProcessError{Path: path, Stage: stageVerify, Err: fmt.Errorf("%w: streamed videos can't be verified", errSkipped)}
+	}
+
+	outDir := (&Video{Location: path, outputDir: opts.OutputDir}).GetOutputDir()
+	name := filepath.Base(path)
+
+	if !FileExists(filepath.Join(outDir, name+".png")) {
+		return &ProcessError{Path: path, Stage: stageVerify, Err: errors.New("no contact sheet")}
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(outDir, name+".json"))
+	if os.IsNotExist(err) {
+		return &ProcessError{Path: path, Stage: stageVerify, Err: errors.New("no info JSON")}
+	} else if err != nil {
+		return &ProcessError{Path: path, Stage: stageVerify, Err: err}
+	}
+
+	var info struct {
+		HashAlgorithm string
+		Hash          struct{ Hex string }
+		SHA1          struct{ Hex string }
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return &ProcessError{Path: path, Stage: stageVerify, Err: fmt.Errorf("unreadable info JSON: %w", err)}
+	}
+
+	// Info JSON from before the hash could be chosen only has SHA1.
+	algo, want := info.HashAlgorithm, info.Hash.Hex
+	if algo == "" {
+		algo, want = hashSHA1, info.SHA1.Hex
+	}
+	if algo == hashNone || want == "" {
+		return nil
+	}
+
+	sum, err := hashFile(ctx, path, algo)
+	if err != nil {
+		return &ProcessError{Path: path, Stage: stageHash, Err: err}
+	}
+	if sum.Hex() != want {
+		return &ProcessError{Path: path, Stage: stageVerify, Err: errors.New("video has changed since its contact sheet was made")}
+	}
+	return nil
+}
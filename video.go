@@ -28,21 +28,47 @@ import (
 )
 
 type Video struct {
-	Filename   string
-	Location   string
-	Duration   float64
-	SHA1       sha1sum
-	Width      int
-	Height     int
-	Codec      string
-	Meta       *ffprobeOutput
+	Filename string
+	// Location is where the video was read from, which for a streamed
+	// video is either its URL or a temporary copy.
+	Location string
+	// Source is the URL, or - for stdin, a streamed video was given as.
+	Source        string `json:",omitempty"`
+	Duration      float64
+	HashAlgorithm string
+	Backend       string
+	Hash          hashsum `json:",omitempty"`
+	SHA1          hashsum `json:",omitempty"` // Only set for sha1, kept for older consumers
+	Width         int
+	Height        int
+	Codec         string
+	Meta          *ffprobeOutput
+	// TrimStart and TrimEnd are the part of the video frames are taken
+	// from, the whole video unless trimmed.
+	TrimStart  float64
+	TrimEnd    float64
 	Step       float64
 	ThumbCount int
+	// AccurateSeek is set when frame times are those of the frames found,
+	// rather than where they were looked for.
+	AccurateSeek bool `json:",omitempty"`
+	// Selection is how Frames were chosen.
+	Selection string
+	Frames    []Frame
+	Chapters  []Chapter       `json:",omitempty"`
+	Subtitles *subtitleSource `json:",omitempty"`
+
+	// tempDir holds the extracted frames until they are composited.
+	tempDir string
+	// outputDir overrides -o for this video.
+	outputDir string
+	// extractWidth overrides -frame-width for this video.
+	extractWidth int
 }
 
-type sha1sum []byte
+type hashsum []byte
 
-func (s sha1sum) MarshalJSON() ([]byte, error) {
+func (s hashsum) MarshalJSON() ([]byte, error) {
 	return json.Marshal(&struct {
 		Bytes []byte
 		Hex   string
@@ -52,15 +78,58 @@ func (s sha1sum) MarshalJSON() ([]byte, error) {
 	})
 }
 
-func (s *sha1sum) Hex() string {
+func (s *hashsum) Hex() string {
 	return strings.TrimLeft(fmt.Sprintf("%x", s), "&")
 }
 
+func (v *Video) setHash(sum hashsum) {
+	v.Hash = sum
+	if v.HashAlgorithm == hashSHA1 {
+		v.SHA1 = sum
+	}
+}
+
+// thumbWidth returns the width frames are extracted at.
+func (v *Video) thumbWidth() int {
+	if v.extractWidth > 0 {
+		return v.extractWidth
+	}
+	return *frameWidth
+}
+
+// framePath returns where the i'th extracted frame is stored while the
+// contact sheet is being built.
+func (v *Video) framePath(i int) string {
+	return filepath.Join(v.tempDir, fmt.Sprintf("%d.png", i))
+}
+
 func (v *Video) GetOutputDir() string {
-	if *outputDir != "" {
+	if v.outputDir != "" {
+		return v.outputDir
+	} else if *outputDir != "" {
 		return *outputDir
-	} else if *outputInPlace {
+	} else if *outputInPlace && v.Source == "" {
 		return filepath.Dir(v.Location)
 	}
 	return *outputDir
 }
+
+// isOutputFile reports whether path looks like something we wrote: an info
+// JSON, or a contact sheet, sprite or extracted frame sitting next to the
+// video it was made from.
+func isOutputFile(path string) bool {
+	ext := filepath.Ext(path)
+	if ext == ".json" {
+		return true
+	}
+
+	video := ""
+	if dir := filepath.Dir(path); filepath.Ext(dir) == ".frames" {
+		video = strings.TrimSuffix(dir, ".frames")
+	} else if ext == ".png" || ext == ".vtt" {
+		video = strings.TrimSuffix(strings.TrimSuffix(path, ext), ".sprite")
+	} else {
+		return false
+	}
+	return FileExists(video) && !IsDir(video)
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2018 Henry Slawniak <https://datacenterscumbags.com/>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"github.com/go-playground/log"
+	"os"
+	"path/filepath"
+)
+
+// fileID identifies a file independently of the path it was reached by.
+type fileID struct {
+	dev, ino uint64
+}
+
+// dirWalker finds files below directories, calling fn once for each file
+// that passes the walk filters no matter how many ways it can be reached.
+type dirWalker struct {
+	ctx context.Context
+	fn  func(string)
+
+	// seen holds the files and directories already visited, keyed by
+	// fileID where the platform has one and by resolved absolute path
+	// otherwise.
+	seen map[interface{}]bool
+}
+
+func newDirWalker(ctx context.Context, fn func(string)) *dirWalker {
+	return &dirWalker{ctx: ctx, fn: fn, seen: map[interface{}]bool{}}
+}
+
+// WalkDir calls fn with every file found below path that passes the walk
+// filters.
+func WalkDir(ctx context.Context, path string, fn func(string)) {
+	newDirWalker(ctx, fn).Walk(path)
+}
+
+// Walk visits every file below root.
+func (w *dirWalker) Walk(root string) {
+	stat, err := os.Stat(root)
+	if err != nil {
+		log.Warnf("Cannot walk %s: %s", root, err)
+		return
+	}
+	if !stat.IsDir() || !w.visit(root, stat) {
+		return
+	}
+	log.Infof("Walking %s", root)
+	w.walk(root, root)
+}
+
+func (w *dirWalker) walk(root, dir string) {
+	// ReadDir returns what it managed to read alongside any error, so a
+	// bad entry doesn't hide the rest of the directory.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Warnf("Cannot read %s: %s", dir, err)
+	}
+
+	for _, entry := range entries {
+		if w.ctx.Err() != nil {
+			return
+		}
+
+		p := filepath.Join(dir, entry.Name())
+		stat, err := os.Lstat(p)
+		if err != nil {
+			log.Warnf("Cannot read %s: %s", p, err)
+			continue
+		}
+
+		if stat.Mode()&os.ModeSymlink != 0 {
+			stat, err = os.Stat(p)
+			if err != nil {
+				log.Warnf("Skipping broken symlink %s: %s", p, err)
+				continue
+			}
+			if stat.IsDir() && !*followSymlinks {
+				log.Debugf("Skipping symlinked directory %s, use -follow-symlinks to walk it", p)
+				continue
+			}
+		}
+
+		if stat.IsDir() {
+			if filter.skipDir(root, p) {
+				log.Debugf("Skipping directory %s", p)
+				continue
+			}
+			// Visiting each directory once stops symlink loops as well
+			// as duplicate walks.
+			if !w.visit(p, stat) {
+				log.Debugf("Skipping %s, already walked", p)
+				continue
+			}
+			w.walk(root, p)
+			continue
+		}
+
+		if !stat.Mode().IsRegular() {
+			continue
+		}
+		if reason := filter.skipFile(root, p, stat); reason != "" {
+			log.Debugf("Skipping %s: %s", p, reason)
+			continue
+		}
+		if !w.visit(p, stat) {
+			log.Debugf("Skipping %s, already found", p)
+			continue
+		}
+		w.fn(p)
+	}
+}
+
+// visit records p as seen, returning false if it already had been.
+func (w *dirWalker) visit(p string, stat os.FileInfo) bool {
+	var key interface{}
+	if id, ok := statFileID(stat); ok {
+		key = id
+	} else if abs, err := filepath.Abs(p); err == nil {
+		// Resolving links gives a loop through a symlink or junction the
+		// same key each time round.
+		if real, err := filepath.EvalSymlinks(abs); err == nil {
+			abs = real
+		}
+		key = abs
+	} else {
+		key = p
+	}
+
+	if w.seen[key] {
+		return false
+	}
+	w.seen[key] = true
+	return true
+}
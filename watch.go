@@ -0,0 +1,201 @@
+// Copyright (c) 2018 Henry Slawniak <https://datacenterscumbags.com/>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/go-playground/log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// errNoNativeWatcher is returned by newNativeWatcher on platforms where we
+// only know how to poll.
+var errNoNativeWatcher = errors.New("file system notifications are not supported on this platform")
+
+// watcher reports paths below a directory that may have been created or
+// changed.
+type watcher interface {
+	Events() <-chan string
+	Close() error
+}
+
+// pendingFile is a file that has arrived but may still be being written.
+type pendingFile struct {
+	size    int64
+	modTime time.Time
+	// stableSince is when size and modTime were last seen to change.
+	stableSince time.Time
+}
+
+// runWatch processes files as they arrive below dir, once they have stopped
+// growing for -settle, until ctx is cancelled.
+func runWatch(ctx context.Context, dir string, summary *runSummary) error {
+	if !FileExists(dir) || !IsDir(dir) {
+		return errors.New(dir + " is not a directory")
+	}
+
+	var w watcher
+	var err error
+	if !*watchPoll {
+		w, err = newNativeWatcher(dir)
+		if err != nil {
+			log.Warnf("%s, polling instead", err)
+		}
+	}
+	if w == nil {
+		w = newPollWatcher(dir, *pollInterval)
+	}
+	defer func() { w.Close() }()
+
+	log.Infof("Watching %s", dir)
+
+	pending := map[string]*pendingFile{}
+	// done remembers what we processed so a file being touched, or our own
+	// writes next to it, doesn't trigger it again.
+	done := map[string]pendingFile{}
+	processed := 0
+
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case p, ok := <-w.Events():
+			if !ok {
+				if ctx.Err() != nil {
+					return nil
+				}
+				log.Warnf("Stopped getting notifications for %s, polling instead", dir)
+				w.Close()
+				w = newPollWatcher(dir, *pollInterval)
+				continue
+			}
+			stat, err := os.Stat(p)
+			if err != nil || stat.IsDir() || isOutputFile(p) {
+				continue
+			}
+			if prev, ok := done[p]; ok && prev.size == stat.Size() && prev.modTime.Equal(stat.ModTime()) {
+				continue
+			}
+			if f, ok := pending[p]; ok && f.size == stat.Size() && f.modTime.Equal(stat.ModTime()) {
+				continue
+			}
+			pending[p] = &pendingFile{size: stat.Size(), modTime: stat.ModTime(), stableSince: time.Now()}
+		case now := <-tick.C:
+			for p, f := range pending {
+				stat, err := os.Stat(p)
+				if err != nil {
+					// Renamed or removed before it settled, the new
+					// name turns up as its own event.
+					delete(pending, p)
+					continue
+				}
+				if stat.Size() != f.size || !stat.ModTime().Equal(f.modTime) {
+					f.size, f.modTime, f.stableSince = stat.Size(), stat.ModTime(), now
+					continue
+				}
+				if now.Sub(f.stableSince) < *settleTime {
+					continue
+				}
+
+				delete(pending, p)
+				done[p] = *f
+				if reason := filter.skipPath(dir, p, stat); reason != "" {
+					log.Debugf("Skipping %s: %s", p, reason)
+					continue
+				}
+				processed++
+				summary.run(ctx, processed-1, 0, fileJob{Path: p})
+				if ctx.Err() != nil {
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// pollWatcher finds changes by walking the tree every interval, for
+// platforms, and file systems such as NFS, without notifications.
+type pollWatcher struct {
+	events chan string
+	stop   chan struct{}
+}
+
+func newPollWatcher(root string, interval time.Duration) *pollWatcher {
+	w := &pollWatcher{
+		events: make(chan string, 64),
+		stop:   make(chan struct{}),
+	}
+
+	go func() {
+		seen := scanTree(root)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-t.C:
+			}
+
+			current := scanTree(root)
+			for p, stat := range current {
+				if prev, ok := seen[p]; ok && prev.size == stat.size && prev.modTime.Equal(stat.modTime) {
+					continue
+				}
+				select {
+				case w.events <- p:
+				case <-w.stop:
+					return
+				}
+			}
+			seen = current
+		}
+	}()
+	return w
+}
+
+func (w *pollWatcher) Events() <-chan string {
+	return w.events
+}
+
+func (w *pollWatcher) Close() error {
+	close(w.stop)
+	return nil
+}
+
+// scanTree returns the size and modification time of every file below root.
+func scanTree(root string) map[string]pendingFile {
+	files := map[string]pendingFile{}
+	filepath.Walk(root, func(p string, stat os.FileInfo, err error) error {
+		if err != nil || stat.IsDir() {
+			return nil
+		}
+		files[p] = pendingFile{size: stat.Size(), modTime: stat.ModTime()}
+		return nil
+	})
+	return files
+}
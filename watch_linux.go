@@ -0,0 +1,169 @@
+// Copyright (c) 2018 Henry Slawniak <https://datacenterscumbags.com/>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/go-playground/log"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE | syscall.IN_MODIFY
+
+// inotifyWatcher watches a tree with inotify, adding watches for directories
+// as they are created.
+type inotifyWatcher struct {
+	root   string
+	fd     int
+	file   *os.File
+	events chan string
+	// stop is closed by Close, so read doesn't block sending events
+	// nobody will receive.
+	stop chan struct{}
+
+	mu   sync.Mutex
+	dirs map[int32]string
+}
+
+func newNativeWatcher(root string) (watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	w := &inotifyWatcher{
+		root: root,
+		fd:   fd,
+		// Wrapping the non-blocking descriptor in a File lets the runtime
+		// poll it, and lets Close interrupt a pending Read.
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		events: make(chan string, 64),
+		stop:   make(chan struct{}),
+		dirs:   map[int32]string{},
+	}
+	if err := w.addTree(root); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+	go w.read()
+	return w, nil
+}
+
+// addTree watches root and every directory below it.
+func (w *inotifyWatcher) addTree(root string) error {
+	return filepath.Walk(root, func(p string, stat os.FileInfo, err error) error {
+		if err != nil || !stat.IsDir() {
+			return nil
+		}
+		wd, err := syscall.InotifyAddWatch(w.fd, p, inotifyMask)
+		if err != nil {
+			return os.NewSyscallError("inotify_add_watch", err)
+		}
+		w.mu.Lock()
+		w.dirs[int32(wd)] = p
+		w.mu.Unlock()
+		return nil
+	})
+}
+
+func (w *inotifyWatcher) read() {
+	defer close(w.events)
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			var ev syscall.InotifyEvent
+			binary.Read(bytes.NewReader(buf[off:off+syscall.SizeofInotifyEvent]), binary.LittleEndian, &ev)
+			nameStart := off + syscall.SizeofInotifyEvent
+			name := string(bytes.TrimRight(buf[nameStart:nameStart+int(ev.Len)], "\x00"))
+			off = nameStart + int(ev.Len)
+
+			if ev.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				// The kernel dropped events while we were busy, so
+				// anything could have changed.
+				log.Warnf("Missed changes to %s, rescanning it", w.root)
+				if !w.sendTree(w.root) {
+					return
+				}
+				continue
+			}
+
+			w.mu.Lock()
+			dir, ok := w.dirs[ev.Wd]
+			w.mu.Unlock()
+			if !ok || name == "" {
+				continue
+			}
+			p := filepath.Join(dir, name)
+
+			if ev.Mask&syscall.IN_ISDIR != 0 {
+				if ev.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+					w.addTree(p)
+					// Files created before the watch was in place
+					// would otherwise be missed.
+					if !w.sendTree(p) {
+						return
+					}
+				}
+				continue
+			}
+			if !w.send(p) {
+				return
+			}
+		}
+	}
+}
+
+// send reports p, returning false if the watcher was closed first.
+func (w *inotifyWatcher) send(p string) bool {
+	select {
+	case w.events <- p:
+		return true
+	case <-w.stop:
+		return false
+	}
+}
+
+// sendTree reports every file below dir.
+func (w *inotifyWatcher) sendTree(dir string) bool {
+	for p := range scanTree(dir) {
+		if !w.send(p) {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *inotifyWatcher) Events() <-chan string {
+	return w.events
+}
+
+func (w *inotifyWatcher) Close() error {
+	close(w.stop)
+	return w.file.Close()
+}